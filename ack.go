@@ -64,16 +64,23 @@ func NewReliableConsumer(id, topic string, handler func(*AckableMessage) error,
 	}
 
 	wrapper := func(msg *Message) error {
+		d := deliveryFromContext(msg.Context()) // acks may come after the handler returned
+		queued := msg.queued() // msg may be a hydrated copy
 		ackable := &AckableMessage{
 			Message: msg,
 			ackFunc: func() error {
+				span := d.settle("ack")
 				rc.mu.Lock()
 				delete(rc.pendingAcks, msg.ID)
 				rc.mu.Unlock()
+				rc.Queue.Release(queued)
+				span.End(nil)
 				return nil
 			},
 			nackFunc: func(requeue bool) error {
-				rc.handleNack(msg, requeue)
+				span := d.settle("nack")
+				rc.handleNack(queued, requeue)
+				span.End(nil)
 				return nil
 			},
 		}
@@ -85,6 +92,7 @@ func NewReliableConsumer(id, topic string, handler func(*AckableMessage) error,
 		return handler(ackable)
 	}
 	rc.Consumer = NewConsumer(id, topic, wrapper, queue)
+	rc.Consumer.manualAck = true
 	return rc
 }
 
@@ -97,11 +105,15 @@ func (rc *ReliableConsumer) handleNack(msg *Message, requeue bool) {
 		// Send to dead letter queue
 		log.Printf("[ReliableConsumer] Message %s exceeded retries, sending to DLQ", msg.ID)
 		rc.dlq.Push(msg)
+		rc.Queue.Release(msg)
 		return
 	}
 	
 	// Retry with delay
 	msg.RetryCount++
 	time.Sleep(rc.retryDelay * time.Duration(msg.RetryCount))
-	rc.Queue.Push(msg)
+	// Unlocks its group and partition, ordered messages go back to the head
+	if err := rc.Queue.redeliver(msg); err != nil {
+		log.Printf("[ReliableConsumer] Failed to requeue message %s: %v", msg.ID, err)
+	}
 }
\ No newline at end of file
@@ -1,6 +1,8 @@
 package lpacamq
 
 import (
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -74,4 +76,48 @@ func TestReliableConsumerDLQ(t *testing.T) {
 	if consumer.dlq.Len() != 1 {
 		t.Errorf("Expected 1 message in DLQ, got %d", consumer.dlq.Len())
 	}
-}
\ No newline at end of file
+}
+func TestReliableConsumerNackPartitioned(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	cfg := DefaultTopicConfig()
+	cfg.Partitions = 2
+	mq.CreateTopicWithConfig("orders", cfg)
+	queue, _ := mq.Subscribe("orders")
+
+	var mu sync.Mutex
+	var seen []string
+	handler := func(msg *AckableMessage) error {
+		mu.Lock()
+		seen = append(seen, string(msg.Payload))
+		first := len(seen) == 1
+		mu.Unlock()
+		if first {
+			return msg.Nack(true)
+		}
+		return msg.Ack()
+	}
+	consumer := NewReliableConsumer("test", "orders", handler, queue, 3)
+	consumer.retryDelay = time.Millisecond
+	consumer.Start()
+	defer consumer.Stop()
+
+	mq.Publish("orders", []byte("o-1"), WithKey("customer-1"))
+	mq.Publish("orders", []byte("o-2"), WithKey("customer-1"))
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(seen)
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(seen, " ") != "o-1 o-1 o-2" {
+		t.Errorf("Expected the nacked message redelivered ahead of its partition, got %v", seen)
+	}
+}
@@ -0,0 +1,223 @@
+package lpacamq
+
+import (
+	"fmt"
+	"sort"
+)
+
+// BatchMessage is one message of a PublishBatchMulti call
+type BatchMessage struct {
+	Topic   string
+	Payload []byte
+	Options []PublishOption
+}
+
+// batchItem is a batch message on its way to its topic
+type batchItem struct {
+	topic *Topic
+	msg   *Message
+	span  Span
+}
+
+// PublishBatch publishes payloads to one topic, all of them or none, with
+// opts applied to each. See PublishBatchMulti
+func (mq *LpacaMQ) PublishBatch(topicName string, payloads [][]byte, opts ...PublishOption) ([]*Message, error) {
+	batch := make([]BatchMessage, len(payloads))
+	for i, payload := range payloads {
+		batch[i] = BatchMessage{Topic: topicName, Payload: payload, Options: opts}
+	}
+	return mq.PublishBatchMulti(batch)
+}
+
+// PublishBatchMulti publishes messages to any number of topics atomically:
+// either every message is enqueued or none is, and the persistent ones are
+// journaled as a single WAL record. The returned messages are in batch
+// order, a repeated idempotency key returns the original like Publish does
+func (mq *LpacaMQ) PublishBatchMulti(batch []BatchMessage) ([]*Message, error) {
+	if len(batch) == 0 {
+		return nil, nil
+	}
+
+	out := make([]*Message, len(batch))
+	items := make([]*batchItem, 0, len(batch))
+	topics := make(map[string]*Topic)
+
+	fail := func(err error) ([]*Message, error) {
+		mq.abandonBatch(items, err)
+		return nil, err
+	}
+
+	for i, bm := range batch {
+		if bm.Topic == "" {
+			return fail(fmt.Errorf("batch message %d: topic name cannot be empty", i))
+		}
+		topic, ok := topics[bm.Topic]
+		if !ok {
+			var err error
+			if topic, err = mq.getOrCreateTopic(bm.Topic); err != nil {
+				return fail(fmt.Errorf("batch message %d: %w", i, err))
+			}
+			topics[bm.Topic] = topic
+		}
+
+		o := applyPublishOptions(bm.Options)
+		msg := newPublishMessage(bm.Topic, bm.Payload, o)
+		if orig, dup := reserveKey(topic, msg); dup {
+			out[i] = orig
+			continue
+		}
+		item := &batchItem{topic: topic, msg: msg, span: mq.tracePublish(msg)}
+		items = append(items, item)
+		out[i] = msg
+
+		if err := mq.prepareMessage(topic, msg, o.encoding); err != nil {
+			return fail(fmt.Errorf("batch message %d: %w", i, err))
+		}
+	}
+
+	record := func(journaled []*Message) *WALEntry {
+		if len(journaled) == 0 {
+			return nil
+		}
+		return &WALEntry{Operation: OpPublishBatch, Messages: journaled}
+	}
+	if err := mq.commitBatch(items, record); err != nil {
+		return fail(err)
+	}
+	for _, item := range items {
+		item.span.End(nil)
+	}
+	return out, nil
+}
+
+// abandonBatch undoes the preparation of messages that were never enqueued
+func (mq *LpacaMQ) abandonBatch(items []*batchItem, err error) {
+	for _, item := range items {
+		if item.msg.IdempotencyKey != "" {
+			item.topic.dedup.forget(item.msg.IdempotencyKey)
+		}
+		mq.discardClaim(item.msg)
+		item.span.End(err)
+	}
+}
+
+// commitBatch journals prepared messages as one WAL record and enqueues
+// them, all or none. record builds the WAL record from the messages of
+// persistent topics, nil writes none
+func (mq *LpacaMQ) commitBatch(items []*batchItem, record func(journaled []*Message) *WALEntry) error {
+	return commit(items, func(journaled []*Message) (*WAL, *WALEntry) {
+		entry := record(journaled)
+		if entry == nil {
+			return nil, nil
+		}
+		// stampWAL takes mq.mu, so the WAL is resolved before any queue lock
+		return mq.stampWAL(entry), entry
+	})
+}
+
+// commit enqueues prepared messages, all or none. Every target queue is
+// locked while the messages are checked, journaled and pushed, so consumers
+// never see part of a batch and a refused message is never journaled.
+// journal returns the WAL and record for the messages of persistent topics
+// that reach a queue, a nil journal or WAL writes nothing
+func commit(items []*batchItem, journal func(journaled []*Message) (*WAL, *WALEntry)) error {
+	targets := make(map[*Queue]*staged)
+	copies := make([]int, len(items))
+	var journaled []*Message
+	for i, item := range items {
+		n, err := item.topic.stage(item.msg, targets)
+		if err != nil {
+			return err
+		}
+		copies[i] = n
+		if n > 0 && item.topic.Config().Persistent {
+			journaled = append(journaled, item.msg)
+		}
+	}
+
+	var wal *WAL
+	var entry *WALEntry
+	if journal != nil {
+		wal, entry = journal(journaled)
+	}
+
+	queues := make([]*Queue, 0, len(targets))
+	for q := range targets {
+		queues = append(queues, q)
+	}
+	// A fixed order keeps concurrent batches from deadlocking each other
+	sort.Slice(queues, func(i, j int) bool { return queues[i].seq < queues[j].seq })
+	for _, q := range queues {
+		q.mu.Lock()
+	}
+	defer func() {
+		for _, q := range queues {
+			q.mu.Unlock()
+		}
+	}()
+
+	for _, q := range queues {
+		if q.closed {
+			return fmt.Errorf("%s is closed", targets[q].name)
+		}
+		if !q.room(len(targets[q].msgs)) {
+			return fmt.Errorf("%s: %w", targets[q].name, ErrQueueFull)
+		}
+	}
+
+	if wal != nil {
+		if err := wal.Write(entry); err != nil {
+			return fmt.Errorf("wal write failed: %w", err)
+		}
+	}
+
+	for i, item := range items {
+		if copies[i] == 0 {
+			item.topic.discardBlob(item.msg) // filtered out everywhere, nothing will release it
+			continue
+		}
+		item.topic.retainBlob(item.msg, copies[i])
+	}
+	for _, q := range queues {
+		for _, msg := range targets[q].msgs {
+			q.push(msg) // room and closed were checked under the same locks
+		}
+	}
+	return nil
+}
+
+// staged is what commit pushes to one queue
+type staged struct {
+	name string // the queue's topic or subscription, for errors
+	msgs []*Message
+}
+
+// stage adds a prepared msg to targets for every queue it fans out to, see
+// fanout. It returns how many queues the message goes to
+func (t *Topic) stage(msg *Message, targets map[*Queue]*staged) (int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.closed {
+		return 0, fmt.Errorf("topic %s is closed", t.Name)
+	}
+	toQueue, subs := t.fanout(msg)
+	n := len(subs)
+	if toQueue {
+		addStaged(targets, t.queue, "topic "+t.Name, msg)
+		n++
+	}
+	for _, sub := range subs {
+		addStaged(targets, sub.queue, "subscription "+sub.Name, msg.clone())
+	}
+	return n, nil
+}
+
+func addStaged(targets map[*Queue]*staged, q *Queue, name string, msg *Message) {
+	st, ok := targets[q]
+	if !ok {
+		st = &staged{name: name}
+		targets[q] = st
+	}
+	st.msgs = append(st.msgs, msg)
+}
@@ -0,0 +1,130 @@
+package lpacamq
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPublishBatch(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	payloads := make([][]byte, 100)
+	for i := range payloads {
+		payloads[i] = []byte(fmt.Sprintf("row-%d", i))
+	}
+	msgs, err := mq.PublishBatch("imports", payloads, WithHeaders(map[string]string{"source": "csv"}))
+	if err != nil {
+		t.Fatalf("PublishBatch failed: %v", err)
+	}
+	if len(msgs) != len(payloads) {
+		t.Fatalf("Expected %d messages, got %d", len(payloads), len(msgs))
+	}
+
+	topic, _ := mq.GetTopic("imports")
+	for i := range payloads {
+		msg, ok := topic.Subscribe().PopNonBlocking()
+		if !ok || string(msg.Payload) != string(payloads[i]) || msg.Headers["source"] != "csv" {
+			t.Fatalf("Expected row-%d in order, got %v", i, msg)
+		}
+	}
+}
+
+func TestPublishBatchAllOrNothing(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	cfg := DefaultTopicConfig()
+	cfg.MaxDepth = 2
+	mq.CreateTopicWithConfig("inventory", cfg)
+	mq.CreateTopic("orders")
+	orders, _ := mq.GetTopic("orders")
+	orders.Subscribe()
+	orders.CreateSubscription("audit")
+
+	_, err := mq.PublishBatchMulti([]BatchMessage{
+		{Topic: "orders", Payload: []byte("o-1")},
+		{Topic: "inventory", Payload: []byte("i-1")},
+		{Topic: "inventory", Payload: []byte("i-2")},
+		{Topic: "inventory", Payload: []byte("i-3")},
+	})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+	inventory, _ := mq.GetTopic("inventory")
+	audit, _ := orders.Subscription("audit")
+	if orders.Len() != 0 || inventory.Len() != 0 || audit.queue.Len() != 0 {
+		t.Errorf("Expected nothing enqueued, got orders=%d audit=%d inventory=%d", orders.Len(), audit.queue.Len(), inventory.Len())
+	}
+
+	schema := []byte(`{"type": "object", "required": ["id"]}`)
+	if _, err := mq.RegisterSchema("orders", schema); err != nil {
+		t.Fatalf("RegisterSchema failed: %v", err)
+	}
+	_, err = mq.PublishBatchMulti([]BatchMessage{
+		{Topic: "inventory", Payload: []byte("i-1"), Options: []PublishOption{WithIdempotencyKey("k-1")}},
+		{Topic: "orders", Payload: []byte(`{"total": 3}`)},
+	})
+	var invalid *SchemaValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected a schema error, got %v", err)
+	}
+	if inventory.Len() != 0 {
+		t.Errorf("Expected nothing enqueued, got %d", inventory.Len())
+	}
+
+	// The failed batch released its idempotency key
+	msgs, err := mq.PublishBatchMulti([]BatchMessage{
+		{Topic: "inventory", Payload: []byte("i-1"), Options: []PublishOption{WithIdempotencyKey("k-1")}},
+		{Topic: "orders", Payload: []byte(`{"id": "o-1"}`)},
+	})
+	if err != nil || len(msgs) != 2 || inventory.Len() != 1 || orders.Len() != 1 || audit.queue.Len() != 1 {
+		t.Errorf("Expected the batch published, got %v", err)
+	}
+}
+
+func TestPublishBatchWAL(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+
+	mq.PublishBatchMulti([]BatchMessage{
+		{Topic: "orders", Payload: []byte("o-1")},
+		{Topic: "inventory", Payload: []byte("i-1")},
+		{Topic: "orders", Payload: []byte("o-2")},
+	})
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	entries, err := wal2.Recover()
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	batches := 0
+	for _, entry := range entries {
+		switch entry.Operation {
+		case OpPublish:
+			t.Errorf("Expected no single publish records")
+		case OpPublishBatch:
+			batches++
+			if len(entry.Messages) != 3 {
+				t.Errorf("Expected 3 messages in the record, got %d", len(entry.Messages))
+			}
+		}
+	}
+	if batches != 1 {
+		t.Errorf("Expected one batch record, got %d", batches)
+	}
+
+	mq2 := New()
+	defer mq2.Close()
+	mq2.AttachWAL(wal2)
+	orders, _ := mq2.GetTopic("orders")
+	inventory, _ := mq2.GetTopic("inventory")
+	if orders == nil || inventory == nil || orders.Len() != 2 || inventory.Len() != 1 {
+		t.Fatalf("Expected the batch restored")
+	}
+}
@@ -0,0 +1,297 @@
+package lpacamq
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BlobRefHeader marks a claim-checked message: its payload was moved to the
+// blob store under this reference and is hydrated on delivery
+const BlobRefHeader = "blob-ref"
+
+// BlobStore keeps large payloads as files in a directory and deletes each
+// one once every delivery of its message is finished
+type BlobStore struct {
+	dir  string
+	refs map[string]int // unfinished deliveries per blob
+	mu   sync.Mutex
+}
+
+// NewBlobStore opens or creates a blob store in dir
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &BlobStore{dir: dir, refs: make(map[string]int)}, nil
+}
+
+// Put stores data and returns its reference
+func (s *BlobStore) Put(data []byte) (string, error) {
+	ref := GenerateID()
+	path := s.path(ref)
+
+	// Write then rename, so a crash never leaves a partial blob behind a ref
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return ref, nil
+}
+
+// Get reads the blob stored under ref
+func (s *BlobStore) Get(ref string) ([]byte, error) {
+	if _, err := ParseID(ref); err != nil {
+		return nil, fmt.Errorf("blob ref: %w", err)
+	}
+	return os.ReadFile(s.path(ref))
+}
+
+// Delete removes the blob stored under ref
+func (s *BlobStore) Delete(ref string) error {
+	if _, err := ParseID(ref); err != nil {
+		return fmt.Errorf("blob ref: %w", err)
+	}
+	s.mu.Lock()
+	delete(s.refs, ref)
+	s.mu.Unlock()
+
+	err := os.Remove(s.path(ref))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *BlobStore) path(ref string) string {
+	return filepath.Join(s.dir, ref)
+}
+
+// retain counts n more deliveries of the blob, a negative n takes back
+// deliveries that never happened
+func (s *BlobStore) retain(ref string, n int) {
+	s.mu.Lock()
+	if s.refs[ref] += n; s.refs[ref] <= 0 {
+		delete(s.refs, ref)
+	}
+	s.mu.Unlock()
+}
+
+// release finishes one delivery of the blob, deleting it after the last
+// Blobs the store isn't tracking, e.g. after a restart without replay, are
+// left alone
+func (s *BlobStore) release(ref string) {
+	s.mu.Lock()
+	n, ok := s.refs[ref]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	if n > 1 {
+		s.refs[ref] = n - 1
+		s.mu.Unlock()
+		return
+	}
+	delete(s.refs, ref)
+	s.mu.Unlock()
+
+	if err := os.Remove(s.path(ref)); err != nil && !os.IsNotExist(err) {
+		log.Printf("[BlobStore] Failed to delete blob %s: %v", ref, err)
+	}
+}
+
+// discard deletes a blob no delivery holds yet
+func (s *BlobStore) discard(ref string) {
+	s.mu.Lock()
+	_, held := s.refs[ref]
+	s.mu.Unlock()
+	if !held {
+		os.Remove(s.path(ref))
+	}
+}
+
+// claimCheck is the broker's blob store and the payload size above which
+// messages are claim-checked
+type claimCheck struct {
+	store     *BlobStore
+	threshold int
+}
+
+// EnableClaimCheck moves payloads larger than threshold bytes into a blob
+// store in dir, keeping them out of queue memory and the WAL. It applies to
+// the broker and all its namespaces, enable it before AttachWAL so recovered
+// messages are tracked for garbage collection
+func (mq *LpacaMQ) EnableClaimCheck(dir string, threshold int) error {
+	if mq.parent != nil {
+		return mq.parent.EnableClaimCheck(dir, threshold)
+	}
+	if threshold <= 0 {
+		return fmt.Errorf("claim-check threshold must be positive")
+	}
+	store, err := NewBlobStore(dir)
+	if err != nil {
+		return err
+	}
+	mq.claims.Store(&claimCheck{store: store, threshold: threshold})
+	return nil
+}
+
+// BlobStore returns the claim-check store, nil when claim-check is off
+func (mq *LpacaMQ) BlobStore() *BlobStore {
+	if cc := mq.claimCheck(); cc != nil {
+		return cc.store
+	}
+	return nil
+}
+
+// claimCheck returns the root's claim-check settings without locking, it is
+// called from queue callbacks that hold q.mu
+func (mq *LpacaMQ) claimCheck() *claimCheck {
+	if mq.parent != nil {
+		return mq.parent.claimCheck()
+	}
+	return mq.claims.Load()
+}
+
+// checkClaim moves a payload over the threshold into the blob store
+func (mq *LpacaMQ) checkClaim(msg *Message) error {
+	cc := mq.claimCheck()
+	if cc == nil || len(msg.Payload) <= cc.threshold {
+		return nil
+	}
+	ref, err := cc.store.Put(msg.Payload)
+	if err != nil {
+		return fmt.Errorf("claim-check: %w", err)
+	}
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string, 1)
+	}
+	msg.Headers[BlobRefHeader] = ref
+	msg.Payload = nil
+	return nil
+}
+
+// discardClaim deletes the blob of a message that failed to publish, unless
+// some queue already holds it
+func (mq *LpacaMQ) discardClaim(msg *Message) {
+	ref := msg.Headers[BlobRefHeader]
+	cc := mq.claimCheck()
+	if ref == "" || cc == nil {
+		return
+	}
+	cc.store.discard(ref)
+}
+
+// Hydrate loads a claim-checked payload back into msg, a no-op for other
+// messages. Handler consumers and Receive hand out hydrated copies, the
+// queued message keeps only the reference
+func (t *Topic) Hydrate(msg *Message) error {
+	ref := msg.Headers[BlobRefHeader]
+	if ref == "" || len(msg.Payload) > 0 {
+		return nil
+	}
+	store := t.blobStore()
+	if store == nil {
+		return fmt.Errorf("message %s is claim-checked but no blob store is configured", msg.ID)
+	}
+	payload, err := store.Get(ref)
+	if err != nil {
+		return fmt.Errorf("hydrate message %s: %w", msg.ID, err)
+	}
+	msg.Payload = payload
+	return nil
+}
+
+// blobStore returns the broker's claim-check store, nil when claim-check is off
+func (t *Topic) blobStore() *BlobStore {
+	if t.blobs == nil {
+		return nil
+	}
+	return t.blobs()
+}
+
+// plainPayload returns msg's payload as published, loaded from store if it
+// was claim-checked and decompressed if it was compressed. Subscription
+// filters match on it
+func plainPayload(msg *Message, store *BlobStore) ([]byte, error) {
+	if ref := msg.Headers[BlobRefHeader]; ref != "" && len(msg.Payload) == 0 {
+		if store == nil {
+			return nil, fmt.Errorf("message %s is claim-checked but no blob store is configured", msg.ID)
+		}
+		payload, err := store.Get(ref)
+		if err != nil {
+			return nil, err
+		}
+		msg = msg.forDelivery()
+		msg.Payload = payload
+	}
+	return msg.decodedPayload()
+}
+
+// hydrated returns msg with its claim-checked payload loaded, as a delivery
+// copy so the payload never stays in queue memory
+func (t *Topic) hydrated(msg *Message) (*Message, error) {
+	if msg.Headers[BlobRefHeader] == "" || len(msg.Payload) > 0 {
+		return msg, nil
+	}
+	out := msg.forDelivery()
+	if err := t.Hydrate(out); err != nil {
+		return msg, err
+	}
+	return out, nil
+}
+
+// retainBlob counts n deliveries of a claim-checked message, see BlobStore.retain
+func (t *Topic) retainBlob(msg *Message, n int) {
+	ref := msg.Headers[BlobRefHeader]
+	if ref == "" || t.blobs == nil {
+		return
+	}
+	if store := t.blobs(); store != nil {
+		store.retain(ref, n)
+	}
+}
+
+// deadLetter moves a message that ran out of retries to the dead-letter
+// queue, counting the dead letter as one more delivery of its blob
+func (t *Topic) deadLetter(msg *Message) error {
+	t.retainBlob(msg, 1)
+	if err := t.deadLetters.Push(msg); err != nil {
+		t.retainBlob(msg, -1)
+		return err
+	}
+	return nil
+}
+
+// discardBlob deletes the blob of a message that reached no queue
+func (t *Topic) discardBlob(msg *Message) {
+	ref := msg.Headers[BlobRefHeader]
+	if ref == "" || t.blobs == nil {
+		return
+	}
+	if store := t.blobs(); store != nil {
+		store.discard(ref)
+	}
+}
+
+// releaseBlobs finishes one delivery of each claim-checked message
+func (t *Topic) releaseBlobs(msgs []*Message) {
+	if t.blobs == nil {
+		return
+	}
+	store := t.blobs()
+	if store == nil {
+		return
+	}
+	for _, msg := range msgs {
+		if ref := msg.Headers[BlobRefHeader]; ref != "" {
+			store.release(ref)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package lpacamq
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func blobCount(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	return len(entries)
+}
+
+func TestClaimCheck(t *testing.T) {
+	dir := t.TempDir()
+	mq := New()
+	defer mq.Close()
+	if err := mq.EnableClaimCheck(dir, 16); err != nil {
+		t.Fatalf("EnableClaimCheck failed: %v", err)
+	}
+
+	mq.CreateTopic("uploads")
+	topic, _ := mq.GetTopic("uploads")
+	topic.Subscribe()
+	topic.CreateSubscription("audit")
+
+	small, _ := mq.Publish("uploads", []byte("tiny"))
+	if small.Headers[BlobRefHeader] != "" {
+		t.Error("Expected a small payload to stay inline")
+	}
+
+	big := bytes.Repeat([]byte("x"), 100)
+	msg, err := mq.Publish("uploads", big)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	ref := msg.Headers[BlobRefHeader]
+	if ref == "" || len(msg.Payload) != 0 {
+		t.Fatalf("Expected the payload claim-checked, got ref %q and %d bytes", ref, len(msg.Payload))
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, ref)); err != nil || !bytes.Equal(data, big) {
+		t.Fatalf("Expected the payload in the blob store: %v", err)
+	}
+
+	got := make(chan []byte, 2)
+	for _, group := range []string{"", "audit"} {
+		mq.SubscribeWithHandler("uploads", func(m *Message) error {
+			if m.Headers[BlobRefHeader] != "" {
+				got <- m.Payload
+			}
+			return nil
+		}, WithGroup(group))
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case payload := <-got:
+			if !bytes.Equal(payload, big) {
+				t.Errorf("Expected a hydrated payload, got %d bytes", len(payload))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Claim-checked message was not delivered")
+		}
+	}
+
+	// Deleted once both the default queue and the subscription acked
+	deadline := time.Now().Add(time.Second)
+	for blobCount(t, dir) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := blobCount(t, dir); n != 0 {
+		t.Errorf("Expected the blob collected, %d left", n)
+	}
+}
+
+func TestClaimCheckReceiveAndPurge(t *testing.T) {
+	dir := t.TempDir()
+	mq := New()
+	defer mq.Close()
+	mq.EnableClaimCheck(dir, 4)
+
+	mq.Publish("files", []byte("first payload"))
+	mq.Publish("files", []byte("second payload"))
+	topic, _ := mq.GetTopic("files")
+
+	msg, receipt, ok := topic.Receive(time.Minute)
+	if !ok || string(msg.Payload) != "first payload" {
+		t.Fatalf("Expected Receive to hydrate, got %v", msg)
+	}
+	topic.Delete(receipt)
+	if n := blobCount(t, dir); n != 1 {
+		t.Errorf("Expected 1 blob after the delete, got %d", n)
+	}
+
+	topic.Purge()
+	if n := blobCount(t, dir); n != 0 {
+		t.Errorf("Expected the purge to collect the last blob, %d left", n)
+	}
+}
+
+func TestClaimCheckIgnoresForeignRef(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	mq.EnableClaimCheck(t.TempDir(), 1024)
+
+	msg, _ := mq.Publish("files", []byte("x"), WithHeaders(map[string]string{BlobRefHeader: "../../etc/passwd"}))
+	if _, ok := msg.Headers[BlobRefHeader]; ok {
+		t.Error("Expected a caller-supplied blob ref to be dropped")
+	}
+}
+
+func TestClaimCheckSurvivesRestart(t *testing.T) {
+	dir, blobs := t.TempDir(), t.TempDir()
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.EnableClaimCheck(blobs, 8)
+	mq.AttachWAL(wal)
+
+	big := bytes.Repeat([]byte("y"), 64)
+	mq.Publish("files", big)
+	wal.Close()
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "wal.log")); bytes.Contains(data, big) {
+		t.Error("Expected the payload kept out of the WAL")
+	}
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	defer mq2.Close()
+	mq2.EnableClaimCheck(blobs, 8)
+	mq2.AttachWAL(wal2)
+
+	topic, _ := mq2.GetTopic("files")
+	msg, receipt, ok := topic.Receive(time.Minute)
+	if !ok || !bytes.Equal(msg.Payload, big) {
+		t.Fatalf("Expected the restored message hydrated, got %v", msg)
+	}
+	topic.Delete(receipt)
+	if n := blobCount(t, blobs); n != 0 {
+		t.Errorf("Expected the blob collected after recovery, %d left", n)
+	}
+}
+
+func TestClaimCheckGroupOnly(t *testing.T) {
+	dir := t.TempDir()
+	mq := New()
+	defer mq.Close()
+	mq.EnableClaimCheck(dir, 4)
+	mq.CreateTopic("uploads")
+
+	got := make(chan []byte, 1)
+	if _, err := mq.SubscribeWithHandler("uploads", func(m *Message) error {
+		got <- m.Payload
+		return nil
+	}, WithGroup("audit"), WithFilter(`headers.kind = "scan"`)); err != nil {
+		t.Fatalf("SubscribeWithHandler failed: %v", err)
+	}
+
+	msg, _ := mq.Publish("uploads", []byte("scanned payload"), WithHeaders(map[string]string{"kind": "scan"}))
+	select {
+	case payload := <-got:
+		if string(payload) != "scanned payload" {
+			t.Errorf("Expected a hydrated payload, got %q", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Claim-checked message was not delivered")
+	}
+	if len(msg.Payload) != 0 {
+		t.Error("Expected the published message left claim-checked")
+	}
+
+	// Nothing consumes the default queue, so the ack of the group collects it
+	deadline := time.Now().Add(time.Second)
+	for blobCount(t, dir) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := blobCount(t, dir); n != 0 {
+		t.Errorf("Expected the blob collected, %d left", n)
+	}
+
+	// A message every subscription filters out is never delivered at all
+	mq.Publish("uploads", []byte("unscanned payload"))
+	if n := blobCount(t, dir); n != 0 {
+		t.Errorf("Expected the blob of an undelivered message discarded, %d left", n)
+	}
+}
+
+func TestClaimCheckRedeliveryStaysClaimed(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	mq.EnableClaimCheck(t.TempDir(), 4)
+	mq.CreateTopic("files")
+	topic, _ := mq.GetTopic("files")
+
+	mq.Publish("files", []byte("big payload"))
+	_, receipt, _ := topic.Receive(time.Minute)
+	topic.ChangeVisibility(receipt, 0)
+
+	queued, _ := topic.Peek()
+	if queued == nil || len(queued.Payload) != 0 {
+		t.Fatal("Expected the queued message to keep only its blob reference")
+	}
+	msg, _, ok := topic.Receive(time.Minute)
+	if !ok || string(msg.Payload) != "big payload" || msg.ReceiveCount != 2 {
+		t.Errorf("Expected the redelivery hydrated again, got %+v", msg)
+	}
+}
+
+func TestClaimCheckDeadLetterKeepsBlob(t *testing.T) {
+	dir := t.TempDir()
+	mq := New()
+	defer mq.Close()
+	mq.EnableClaimCheck(dir, 4)
+	cfg := DefaultTopicConfig()
+	cfg.MaxRetries = 1
+	mq.CreateTopicWithConfig("files", cfg)
+	topic, _ := mq.GetTopic("files")
+
+	mq.SubscribeWithHandler("files", func(*Message) error {
+		return fmt.Errorf("cannot process")
+	})
+	mq.Publish("files", []byte("big payload"))
+
+	deadline := time.Now().Add(time.Second)
+	for topic.DeadLetters().Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	dead, ok := topic.DeadLetters().Peek()
+	if !ok {
+		t.Fatal("Expected the message dead-lettered")
+	}
+	if hydrated, err := topic.hydrated(dead); err != nil || string(hydrated.Payload) != "big payload" {
+		t.Fatalf("Expected the dead letter hydrated, got %v", err)
+	}
+
+	topic.DeadLetters().PopNonBlocking()
+	if n := blobCount(t, dir); n != 0 {
+		t.Errorf("Expected the blob collected once the dead letter was consumed, %d left", n)
+	}
+}
@@ -0,0 +1,354 @@
+package lpacamq
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransformFunc reshapes a bridged message. It returns the messages to
+// publish: none filters the message out, several split it. Only Payload,
+// Headers, Key and GroupID of an output are used, and its Topic, if changed
+// from the source topic, overrides the bridge's destinations. An output
+// Topic matching one of the bridge's sources fails like Destinations would
+type TransformFunc func(msg *Message) ([]*Message, error)
+
+// MapPayload builds a transform that rewrites each payload
+func MapPayload(fn func(payload []byte) ([]byte, error)) TransformFunc {
+	return func(msg *Message) ([]*Message, error) {
+		payload, err := fn(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		out := msg.clone()
+		out.Payload = payload
+		return []*Message{out}, nil
+	}
+}
+
+// FilterMessages builds a transform that forwards only messages matching keep
+func FilterMessages(keep MessageFilter) TransformFunc {
+	return func(msg *Message) ([]*Message, error) {
+		if !keep(msg) {
+			return nil, nil
+		}
+		return []*Message{msg}, nil
+	}
+}
+
+// SplitPayload builds a transform that publishes every payload fn returns
+func SplitPayload(fn func(payload []byte) ([][]byte, error)) TransformFunc {
+	return func(msg *Message) ([]*Message, error) {
+		parts, err := fn(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		outs := make([]*Message, len(parts))
+		for i, part := range parts {
+			outs[i] = msg.clone()
+			outs[i].Payload = part
+		}
+		return outs, nil
+	}
+}
+
+// BridgeErrorPolicy decides what happens to a message whose transform or
+// republish failed
+type BridgeErrorPolicy string
+
+const (
+	BridgeErrorDrop       BridgeErrorPolicy = "drop"        // count it and move on
+	BridgeErrorDeadLetter BridgeErrorPolicy = "dead-letter" // keep it on the bridge's dead-letter queue
+	BridgeErrorRetry      BridgeErrorPolicy = "retry"       // fail the delivery, the source topic's retry settings apply
+)
+
+// A bridge with BridgeErrorRetry on a source topic without MaxRetries retries
+// the message itself, with a growing delay, then dead-letters it
+const (
+	bridgeRetries    = 3
+	bridgeRetryDelay = 50 * time.Millisecond
+)
+
+// BridgeConfig declares a bridge
+type BridgeConfig struct {
+	Name         string
+	Sources      []string // topic names or patterns
+	Destinations []string
+	Transform    TransformFunc // nil forwards messages unchanged
+	OnError      BridgeErrorPolicy
+	ErrorHandler func(msg *Message, err error) // optional, called on every failure
+}
+
+// BridgeStats counts what a bridge has done
+type BridgeStats struct {
+	Name         string    `json:"name"`
+	Sources      []string  `json:"sources"`
+	Destinations []string  `json:"destinations"`
+	Received     uint64    `json:"received"`
+	Published    uint64    `json:"published"`
+	Filtered     uint64    `json:"filtered"`
+	Failed       uint64    `json:"failed"`
+	DeadLetters  int       `json:"dead_letters"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastErrorAt  time.Time `json:"last_error_at,omitzero"`
+}
+
+// Bridge consumes its source topics through its own subscription,
+// transforms each message and republishes the result to its destinations
+// Delivery is at least once: a split message that fails halfway and is
+// retried republishes the parts already sent
+type Bridge struct {
+	Name string
+
+	cfg         BridgeConfig
+	mq          *LpacaMQ
+	subs        []*PatternSubscription
+	deadLetters *Queue
+
+	received, published, filtered, failed uint64 // atomic
+	lastErr                               string
+	lastErrAt                             time.Time
+	mu                                    sync.Mutex // protects lastErr
+}
+
+// CreateBridge starts a bridge. Each source is consumed through a named
+// subscription "bridge-<name>", so other consumers of the source are unaffected
+// and topics matching a source pattern later are bridged too
+func (mq *LpacaMQ) CreateBridge(cfg BridgeConfig) (*Bridge, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("bridge name cannot be empty")
+	}
+	if len(cfg.Sources) == 0 || len(cfg.Destinations) == 0 {
+		return nil, fmt.Errorf("bridge %s needs at least one source and one destination", cfg.Name)
+	}
+	if cfg.OnError == "" {
+		cfg.OnError = BridgeErrorDrop
+	}
+	switch cfg.OnError {
+	case BridgeErrorDrop, BridgeErrorDeadLetter, BridgeErrorRetry:
+	default:
+		return nil, fmt.Errorf("unknown bridge error policy %q", cfg.OnError)
+	}
+	for _, dest := range cfg.Destinations {
+		if IsTopicPattern(dest) {
+			return nil, fmt.Errorf("bridge destination %s cannot be a pattern", dest)
+		}
+		for _, src := range cfg.Sources {
+			if MatchTopic(src, dest) {
+				return nil, fmt.Errorf("bridge %s would loop: destination %s matches source %s", cfg.Name, dest, src)
+			}
+		}
+	}
+
+	b := &Bridge{
+		Name:        cfg.Name,
+		cfg:         cfg,
+		mq:          mq,
+		deadLetters: NewQueue(),
+	}
+
+	mq.mu.Lock()
+	if _, exists := mq.bridges[cfg.Name]; exists {
+		mq.mu.Unlock()
+		return nil, fmt.Errorf("bridge %s already exists", cfg.Name)
+	}
+	mq.bridges[cfg.Name] = b
+	mq.mu.Unlock()
+
+	for _, src := range cfg.Sources {
+		ps, err := mq.SubscribePattern(src, b.handle, WithGroup(b.group()))
+		if err != nil {
+			mq.DeleteBridge(cfg.Name)
+			return nil, fmt.Errorf("bridge %s source %s: %w", cfg.Name, src, err)
+		}
+		b.subs = append(b.subs, ps)
+	}
+
+	log.Printf("[LpacaMQ] Bridge %s: %v -> %v", cfg.Name, cfg.Sources, cfg.Destinations)
+	return b, nil
+}
+
+// GetBridge returns a running bridge
+func (mq *LpacaMQ) GetBridge(name string) (*Bridge, error) {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	b, exists := mq.bridges[name]
+	if !exists {
+		return nil, fmt.Errorf("bridge %s not found", name)
+	}
+	return b, nil
+}
+
+// ListBridges returns the stats of every bridge, ordered by name
+func (mq *LpacaMQ) ListBridges() []BridgeStats {
+	mq.mu.RLock()
+	bridges := make([]*Bridge, 0, len(mq.bridges))
+	for _, b := range mq.bridges {
+		bridges = append(bridges, b)
+	}
+	mq.mu.RUnlock()
+
+	stats := make([]BridgeStats, len(bridges))
+	for i, b := range bridges {
+		stats[i] = b.Stats()
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// DeleteBridge stops a bridge and drops its subscriptions on the sources
+func (mq *LpacaMQ) DeleteBridge(name string) error {
+	mq.mu.Lock()
+	b, exists := mq.bridges[name]
+	delete(mq.bridges, name)
+	mq.mu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("bridge %s not found", name)
+	}
+
+	for _, ps := range b.subs {
+		topics := ps.Topics()
+		mq.UnsubscribePattern(ps.ID)
+		for _, topicName := range topics {
+			if topic, err := mq.GetTopic(topicName); err == nil {
+				topic.DeleteSubscription(b.group())
+			}
+		}
+	}
+	b.deadLetters.Close()
+	log.Printf("[LpacaMQ] Bridge deleted: %s", name)
+	return nil
+}
+
+// Stats returns the bridge's counters
+func (b *Bridge) Stats() BridgeStats {
+	b.mu.Lock()
+	lastErr, lastErrAt := b.lastErr, b.lastErrAt
+	b.mu.Unlock()
+
+	return BridgeStats{
+		Name:         b.Name,
+		Sources:      b.cfg.Sources,
+		Destinations: b.cfg.Destinations,
+		Received:     atomic.LoadUint64(&b.received),
+		Published:    atomic.LoadUint64(&b.published),
+		Filtered:     atomic.LoadUint64(&b.filtered),
+		Failed:       atomic.LoadUint64(&b.failed),
+		DeadLetters:  b.deadLetters.Len(),
+		LastError:    lastErr,
+		LastErrorAt:  lastErrAt,
+	}
+}
+
+// DeadLetters returns the messages the bridge gave up on under BridgeErrorDeadLetter
+func (b *Bridge) DeadLetters() *Queue {
+	return b.deadLetters
+}
+
+func (b *Bridge) group() string {
+	return "bridge-" + b.Name
+}
+
+// source returns the source pattern a topic name matches, "" if none
+func (b *Bridge) source(topic string) string {
+	for _, src := range b.cfg.Sources {
+		if MatchTopic(src, topic) {
+			return src
+		}
+	}
+	return ""
+}
+
+// handle transforms and republishes one source message
+func (b *Bridge) handle(msg *Message) error {
+	atomic.AddUint64(&b.received, 1)
+
+	err := b.forward(msg)
+	if err == nil {
+		return nil
+	}
+	b.record(msg, err)
+
+	switch b.cfg.OnError {
+	case BridgeErrorRetry:
+		if b.sourceRetries(msg.Topic) {
+			return err
+		}
+		// Nothing would redeliver it, and a nil error would drop it
+		for attempt := 1; attempt <= bridgeRetries; attempt++ {
+			time.Sleep(bridgeRetryDelay * time.Duration(attempt))
+			if err = b.forward(msg); err == nil {
+				return nil
+			}
+			b.record(msg, err)
+		}
+		b.deadLetter(msg)
+	case BridgeErrorDeadLetter:
+		b.deadLetter(msg)
+	}
+	return nil
+}
+
+// forward transforms and republishes one message, an error means it was not
+// (fully) bridged
+func (b *Bridge) forward(msg *Message) error {
+	outs := []*Message{msg}
+	if b.cfg.Transform != nil {
+		var err error
+		if outs, err = b.cfg.Transform(msg); err != nil {
+			return fmt.Errorf("transform: %w", err)
+		}
+	}
+	if len(outs) == 0 {
+		atomic.AddUint64(&b.filtered, 1)
+		return nil
+	}
+
+	for _, out := range outs {
+		dests := b.cfg.Destinations
+		if out.Topic != "" && out.Topic != msg.Topic {
+			if src := b.source(out.Topic); src != "" {
+				return fmt.Errorf("transform output to %s would loop: it matches source %s", out.Topic, src)
+			}
+			dests = []string{out.Topic}
+		}
+		for _, dest := range dests {
+			_, err := b.mq.Publish(dest, out.Payload, WithHeaders(out.Headers), WithKey(out.Key), WithGroupID(out.GroupID))
+			if err != nil {
+				return fmt.Errorf("publish to %s: %w", dest, err)
+			}
+			atomic.AddUint64(&b.published, 1)
+		}
+	}
+	return nil
+}
+
+// record counts a failure and reports it
+func (b *Bridge) record(msg *Message, err error) {
+	atomic.AddUint64(&b.failed, 1)
+	b.mu.Lock()
+	b.lastErr, b.lastErrAt = err.Error(), time.Now()
+	b.mu.Unlock()
+
+	log.Printf("[Bridge %s] Message %s from %s failed: %v", b.Name, msg.ID, msg.Topic, err)
+	if b.cfg.ErrorHandler != nil {
+		b.cfg.ErrorHandler(msg, err)
+	}
+}
+
+// sourceRetries reports whether the source topic redelivers a failed message
+func (b *Bridge) sourceRetries(topicName string) bool {
+	topic, err := b.mq.GetTopic(topicName)
+	return err == nil && topic.Config().MaxRetries > 0
+}
+
+func (b *Bridge) deadLetter(msg *Message) {
+	if err := b.deadLetters.Push(msg.clone()); err != nil {
+		log.Printf("[Bridge %s] Failed to dead-letter message %s: %v", b.Name, msg.ID, err)
+	}
+}
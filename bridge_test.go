@@ -0,0 +1,227 @@
+package lpacamq
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBridgeTransform(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	mq.CreateTopic("raw.events")
+	mq.CreateTopic("clean.events")
+
+	_, err := mq.CreateBridge(BridgeConfig{
+		Name:         "clean",
+		Sources:      []string{"raw.events"},
+		Destinations: []string{"clean.events"},
+		Transform: func(msg *Message) ([]*Message, error) {
+			if bytes.HasPrefix(msg.Payload, []byte("#")) {
+				return nil, nil // comments are filtered out
+			}
+			var outs []*Message
+			for _, part := range strings.Split(string(msg.Payload), ",") {
+				out := msg.clone()
+				out.Payload = []byte(strings.ToUpper(part))
+				outs = append(outs, out)
+			}
+			return outs, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateBridge failed: %v", err)
+	}
+
+	// A plain consumer of the source still sees everything
+	raw, _ := mq.GetTopic("raw.events")
+	raw.Subscribe()
+	mq.Publish("raw.events", []byte("a,b"))
+	mq.Publish("raw.events", []byte("# skip"))
+	mq.Publish("raw.events", []byte("c"))
+
+	time.Sleep(100 * time.Millisecond)
+
+	clean, _ := mq.GetTopic("clean.events")
+	var got []string
+	for _, msg := range clean.Browse(0, 0) {
+		got = append(got, string(msg.Payload))
+	}
+	if strings.Join(got, " ") != "A B C" {
+		t.Errorf("Expected A B C on clean.events, got %v", got)
+	}
+	if raw.Len() != 3 {
+		t.Errorf("Expected the source's default queue untouched, got %d", raw.Len())
+	}
+
+	b, _ := mq.GetBridge("clean")
+	stats := b.Stats()
+	if stats.Received != 3 || stats.Published != 3 || stats.Filtered != 1 || stats.Failed != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	mq.DeleteBridge("clean")
+	if _, ok := raw.Subscription("bridge-clean"); ok {
+		t.Error("Expected the bridge subscription removed")
+	}
+}
+
+func TestBridgeErrorPolicies(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	var handled []error
+	failing := MapPayload(func(payload []byte) ([]byte, error) {
+		return nil, fmt.Errorf("cannot parse %s", payload)
+	})
+	b, err := mq.CreateBridge(BridgeConfig{
+		Name:         "strict",
+		Sources:      []string{"in"},
+		Destinations: []string{"out"},
+		Transform:    failing,
+		OnError:      BridgeErrorDeadLetter,
+		ErrorHandler: func(msg *Message, err error) { handled = append(handled, err) },
+	})
+	if err != nil {
+		t.Fatalf("CreateBridge failed: %v", err)
+	}
+
+	mq.Publish("in", []byte("garbage"))
+	time.Sleep(100 * time.Millisecond)
+
+	stats := b.Stats()
+	if stats.Failed != 1 || stats.DeadLetters != 1 || !strings.Contains(stats.LastError, "garbage") {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if len(handled) != 1 {
+		t.Errorf("Expected the error handler called once, got %d", len(handled))
+	}
+
+	if _, err := mq.CreateBridge(BridgeConfig{Name: "loop", Sources: []string{"events.#"}, Destinations: []string{"events.copy"}}); err == nil {
+		t.Error("Expected a looping bridge to be refused")
+	}
+}
+
+func TestBridgeRetryWithoutSourceRetries(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	mq.CreateTopic("in") // MaxRetries 0, nothing would redeliver a failed message
+
+	var attempts int32
+	b, err := mq.CreateBridge(BridgeConfig{
+		Name:         "flaky",
+		Sources:      []string{"in"},
+		Destinations: []string{"out"},
+		Transform: func(msg *Message) ([]*Message, error) {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				return nil, fmt.Errorf("not yet")
+			}
+			return []*Message{msg}, nil
+		},
+		OnError: BridgeErrorRetry,
+	})
+	if err != nil {
+		t.Fatalf("CreateBridge failed: %v", err)
+	}
+
+	mq.Publish("in", []byte("late"))
+	time.Sleep(300 * time.Millisecond)
+
+	out, _ := mq.GetTopic("out")
+	if out == nil || out.Len() != 1 {
+		t.Fatalf("Expected the message bridged after retrying, got %+v", b.Stats())
+	}
+	if stats := b.Stats(); stats.Failed != 2 || stats.Published != 1 || stats.DeadLetters != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestBridgeTransformLoopRefused(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	b, err := mq.CreateBridge(BridgeConfig{
+		Name:         "echo",
+		Sources:      []string{"events.*"},
+		Destinations: []string{"audit"},
+		Transform: func(msg *Message) ([]*Message, error) {
+			out := msg.clone()
+			out.Topic = "events.copy"
+			return []*Message{out}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateBridge failed: %v", err)
+	}
+
+	mq.Publish("events.in", []byte("e-1"))
+	time.Sleep(100 * time.Millisecond)
+
+	if stats := b.Stats(); stats.Failed != 1 || stats.Published != 0 {
+		t.Errorf("Expected the looping output refused, got %+v", stats)
+	}
+	if topic, err := mq.GetTopic("events.copy"); err == nil && topic.Len() != 0 {
+		t.Errorf("Expected nothing published to events.copy, got %d", topic.Len())
+	}
+}
+
+func TestBridgeFromConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Bridges = []BridgeConfig{{
+		Name:         "fanout",
+		Sources:      []string{"orders.*"},
+		Destinations: []string{"audit", "archive"},
+	}}
+	mq, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewWithConfig failed: %v", err)
+	}
+	defer mq.Close()
+
+	mq.Publish("orders.eu", []byte("o-1"))
+	time.Sleep(100 * time.Millisecond)
+
+	for _, name := range []string{"audit", "archive"} {
+		topic, err := mq.GetTopic(name)
+		if err != nil || topic.Len() != 1 {
+			t.Errorf("Expected the order bridged to %s", name)
+		}
+	}
+	if stats := mq.ListBridges(); len(stats) != 1 || stats[0].Published != 2 {
+		t.Errorf("Unexpected bridge stats %+v", stats)
+	}
+}
+
+func TestBridgeFromConfigSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+	cfg := DefaultConfig()
+	cfg.Bridges = []BridgeConfig{{Name: "copy", Sources: []string{"raw"}, Destinations: []string{"clean"}}}
+	mq, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewWithConfig failed: %v", err)
+	}
+	mq.CreateTopic("raw")
+	if err := mq.AttachWAL(wal); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	defer mq2.Close()
+	if err := mq2.AttachWAL(wal2); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+	raw, err := mq2.GetTopic("raw")
+	if err != nil {
+		t.Fatalf("Expected raw restored: %v", err)
+	}
+	if _, ok := raw.Subscription("bridge-copy"); !ok {
+		t.Error("Expected the bridge subscription made before the WAL restored")
+	}
+}
@@ -20,7 +20,10 @@ func main() {
 	log.Println("Starting LpacaMQ...")
 	
 	// Create message queue
-	mq := lpaca.New()
+	mq, err := lpaca.NewWithConfig(lpaca.DefaultConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
 	
 	// Setup persistence (optional)
 	wal, err := lpaca.NewWAL(*dataDir)
@@ -28,7 +31,9 @@ func main() {
 		log.Printf("WAL not available: %v", err)
 	} else {
 		defer wal.Close()
-		// Recover if needed
+		if err := mq.AttachWAL(wal); err != nil {
+			log.Printf("WAL recovery failed: %v", err)
+		}
 	}
 	
 	// Create some example consumers
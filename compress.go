@@ -0,0 +1,206 @@
+package lpacamq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ContentEncodingHeader records how a message's payload is compressed
+const ContentEncodingHeader = "content-encoding"
+
+// ErrUnsupportedEncoding is returned for a content encoding the broker can't
+// decompress
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// MaxDecompressedSize caps a decompressed payload, so a small compressed
+// bomb can't exhaust the broker's memory
+const MaxDecompressedSize = 64 << 20
+
+// ErrDecompressedTooLarge is returned for a payload that would decompress to
+// more than MaxDecompressedSize bytes
+var ErrDecompressedTooLarge = fmt.Errorf("payload decompresses to more than %d bytes", MaxDecompressedSize)
+
+// Payload encodings, deflate is the zlib format as in HTTP (RFC 1950)
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// compression is the broker's encoding for payloads over threshold bytes
+type compression struct {
+	encoding  string
+	threshold int
+}
+
+// SetCompression makes the broker compress payloads larger than threshold
+// bytes with encoding (EncodingGzip or EncodingDeflate), an empty encoding
+// turns it off. It applies to the broker and all its namespaces. Producers
+// can also compress a single message with WithCompression, or publish an
+// already compressed payload with a content-encoding header
+func (mq *LpacaMQ) SetCompression(encoding string, threshold int) error {
+	if mq.parent != nil {
+		return mq.parent.SetCompression(encoding, threshold)
+	}
+	if encoding == "" {
+		mq.compression.Store(nil)
+		return nil
+	}
+	if !supportedEncoding(encoding) {
+		return fmt.Errorf("%w %q", ErrUnsupportedEncoding, encoding)
+	}
+	if threshold < 0 {
+		return fmt.Errorf("compression threshold cannot be negative")
+	}
+	mq.compression.Store(&compression{encoding: encoding, threshold: threshold})
+	return nil
+}
+
+// compressionConfig returns the root's compression settings
+func (mq *LpacaMQ) compressionConfig() *compression {
+	if mq.parent != nil {
+		return mq.parent.compressionConfig()
+	}
+	return mq.compression.Load()
+}
+
+// compress encodes msg's payload with the producer's encoding, or the
+// broker's if the payload is over its threshold. A payload the producer
+// already encoded is left alone, and so is one compression wouldn't shrink
+func (mq *LpacaMQ) compress(msg *Message, encoding string) error {
+	if enc, ok := msg.Headers[ContentEncodingHeader]; ok {
+		if !supportedEncoding(enc) {
+			return fmt.Errorf("%w %q", ErrUnsupportedEncoding, enc)
+		}
+		return nil
+	}
+	if encoding == "" {
+		cfg := mq.compressionConfig()
+		if cfg == nil || len(msg.Payload) <= cfg.threshold {
+			return nil
+		}
+		encoding = cfg.encoding
+	}
+
+	payload, err := compressPayload(encoding, msg.Payload)
+	if err != nil {
+		return err
+	}
+	if len(payload) >= len(msg.Payload) {
+		return nil
+	}
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string, 1)
+	}
+	msg.Headers[ContentEncodingHeader] = encoding
+	msg.Payload = payload
+	return nil
+}
+
+// Decompress decodes the payload as its content-encoding header says and
+// drops the header, a no-op for uncompressed messages. Handler consumers and
+// Receive hand out decompressed copies unless raw payloads were asked for,
+// the queued message stays compressed
+func (m *Message) Decompress() error {
+	encoding, ok := m.Headers[ContentEncodingHeader]
+	if !ok {
+		return nil
+	}
+	payload, err := decompressPayload(encoding, m.Payload)
+	if err != nil {
+		return fmt.Errorf("decompress message %s: %w", m.ID, err)
+	}
+
+	// Headers are shared with the message's copies on other subscriptions
+	headers := copyHeaders(m.Headers)
+	delete(headers, ContentEncodingHeader)
+	m.Payload, m.Headers = payload, headers
+	return nil
+}
+
+// decompressed returns m with its payload decompressed, as a delivery copy
+// so a redelivery, possibly of raw payloads, starts from the original again
+func (m *Message) decompressed() (*Message, error) {
+	if _, ok := m.Headers[ContentEncodingHeader]; !ok {
+		return m, nil
+	}
+	out := m.forDelivery()
+	if err := out.Decompress(); err != nil {
+		return m, err
+	}
+	return out, nil
+}
+
+// decodedPayload returns msg's payload without its content encoding
+func (m *Message) decodedPayload() ([]byte, error) {
+	encoding, ok := m.Headers[ContentEncodingHeader]
+	if !ok {
+		return m.Payload, nil
+	}
+	return decompressPayload(encoding, m.Payload)
+}
+
+func supportedEncoding(encoding string) bool {
+	switch encoding {
+	case EncodingGzip, EncodingDeflate, "identity":
+		return true
+	}
+	return false
+}
+
+func compressPayload(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingDeflate:
+		w = zlib.NewWriter(&buf)
+	case "identity":
+		return data, nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressPayload(encoding string, data []byte) ([]byte, error) {
+	var r io.ReadCloser
+	switch encoding {
+	case EncodingGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	case EncodingDeflate:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case "identity":
+		return data, nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, encoding)
+	}
+	defer r.Close()
+
+	payload, err := io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > MaxDecompressedSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return payload, nil
+}
@@ -0,0 +1,165 @@
+package lpacamq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBrokerCompression(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	if err := mq.SetCompression(EncodingGzip, 64); err != nil {
+		t.Fatalf("SetCompression failed: %v", err)
+	}
+
+	small, _ := mq.Publish("logs", []byte("short line"))
+	if _, ok := small.Headers[ContentEncodingHeader]; ok {
+		t.Error("Expected a payload under the threshold to stay uncompressed")
+	}
+
+	big := bytes.Repeat([]byte("log line "), 100)
+	msg, err := mq.Publish("logs", big)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if msg.Headers[ContentEncodingHeader] != EncodingGzip || len(msg.Payload) >= len(big) {
+		t.Fatalf("Expected a gzipped payload, got %q and %d bytes", msg.Headers[ContentEncodingHeader], len(msg.Payload))
+	}
+
+	got := make(chan *Message, 2)
+	mq.SubscribeWithHandler("logs", func(m *Message) error {
+		got <- m
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-got:
+			if _, ok := m.Headers[ContentEncodingHeader]; ok {
+				t.Error("Expected the handler to see a decompressed message")
+			}
+			if i == 1 && !bytes.Equal(m.Payload, big) {
+				t.Errorf("Expected the original payload, got %d bytes", len(m.Payload))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Message was not delivered")
+		}
+	}
+
+	if err := mq.SetCompression("br", 0); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Errorf("Expected ErrUnsupportedEncoding, got %v", err)
+	}
+}
+
+func TestProducerCompression(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	payload := bytes.Repeat([]byte("abc"), 50)
+	msg, err := mq.Publish("events", payload, WithCompression(EncodingDeflate))
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if msg.Headers[ContentEncodingHeader] != EncodingDeflate {
+		t.Fatalf("Expected deflate, got %q", msg.Headers[ContentEncodingHeader])
+	}
+
+	// A payload the producer compressed itself passes through untouched
+	pre, _ := compressPayload(EncodingGzip, payload)
+	mq.Publish("events", pre, WithHeaders(map[string]string{ContentEncodingHeader: EncodingGzip}))
+
+	if _, err := mq.Publish("events", payload, WithHeaders(map[string]string{ContentEncodingHeader: "br"})); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Errorf("Expected ErrUnsupportedEncoding, got %v", err)
+	}
+
+	got := make(chan *Message, 2)
+	mq.SubscribeWithHandler("events", func(m *Message) error {
+		got <- m
+		return nil
+	}, WithRawPayload())
+	for _, want := range []string{EncodingDeflate, EncodingGzip} {
+		select {
+		case m := <-got:
+			if m.Headers[ContentEncodingHeader] != want {
+				t.Errorf("Expected a raw %s payload, got %q", want, m.Headers[ContentEncodingHeader])
+			}
+			if err := m.Decompress(); err != nil || !bytes.Equal(m.Payload, payload) {
+				t.Errorf("Decompress failed: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Message was not delivered")
+		}
+	}
+}
+
+func TestCompressionReceive(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	mq.SetCompression(EncodingGzip, 0)
+
+	payload := bytes.Repeat([]byte("z"), 200)
+	mq.Publish("jobs", payload)
+	mq.Publish("jobs", payload)
+	topic, _ := mq.GetTopic("jobs")
+
+	msg, _, ok := topic.Receive(time.Minute)
+	if !ok || !bytes.Equal(msg.Payload, payload) {
+		t.Fatalf("Expected Receive to decompress, got %v", msg)
+	}
+	raw, _, ok := topic.receive(time.Minute, true)
+	if !ok || raw.Headers[ContentEncodingHeader] != EncodingGzip {
+		t.Fatalf("Expected a raw receive to keep the encoding, got %v", raw)
+	}
+}
+
+func TestCompressionRedeliveryRaw(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	mq.SetCompression(EncodingGzip, 0)
+	mq.CreateTopic("jobs")
+	topic, _ := mq.GetTopic("jobs")
+
+	payload := bytes.Repeat([]byte("z"), 200)
+	published, _ := mq.Publish("jobs", payload)
+	compressed := published.Payload
+
+	_, receipt, _ := topic.Receive(time.Minute)
+	topic.ChangeVisibility(receipt, 0)
+	if !bytes.Equal(published.Payload, compressed) || published.Headers[ContentEncodingHeader] != EncodingGzip {
+		t.Fatal("Expected the published message left compressed")
+	}
+
+	raw, _, ok := topic.receive(time.Minute, true)
+	if !ok || raw.Headers[ContentEncodingHeader] != EncodingGzip || !bytes.Equal(raw.Payload, compressed) {
+		t.Errorf("Expected the redelivery raw, got %v", raw)
+	}
+}
+
+func TestDeflateIsZlib(t *testing.T) {
+	payload := bytes.Repeat([]byte("abc"), 50)
+	data, err := compressPayload(EncodingDeflate, payload)
+	if err != nil {
+		t.Fatalf("compressPayload failed: %v", err)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected a zlib stream: %v", err)
+	}
+	if got, _ := io.ReadAll(r); !bytes.Equal(got, payload) {
+		t.Error("Expected the zlib stream to hold the payload")
+	}
+}
+
+func TestDecompressBounded(t *testing.T) {
+	bomb, _ := compressPayload(EncodingGzip, make([]byte, MaxDecompressedSize+1))
+	if _, err := decompressPayload(EncodingGzip, bomb); !errors.Is(err, ErrDecompressedTooLarge) {
+		t.Errorf("Expected ErrDecompressedTooLarge, got %v", err)
+	}
+	fits, _ := compressPayload(EncodingGzip, make([]byte, 1024))
+	if data, err := decompressPayload(EncodingGzip, fits); err != nil || len(data) != 1024 {
+		t.Errorf("Expected a payload under the cap decompressed, got %d bytes, %v", len(data), err)
+	}
+}
@@ -1,21 +1,50 @@
 package lpacamq
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type Config struct {
-	MaxQueueDepth      int
-	DefaultMaxRetries  int
-	RetryDelay         time.Duration
-	AutoCreateTopics   bool
-	ConsumerBufferSize int
+	MaxQueueDepth        int            // default MaxDepth of new topics, 0 is unbounded
+	DefaultMaxRetries    int            // default MaxRetries of new topics
+	RetryDelay           time.Duration  // default RetryBackoff of new topics
+	AutoCreateTopics     bool           // Publish and Subscribe create missing topics
+	ConsumerBufferSize   int            // messages each handler consumer prefetches, 0 fetches one at a time
+	Bridges              []BridgeConfig // started by NewWithConfig
+	BlobDir              string         // claim-check store for large payloads, empty keeps payloads inline
+	ClaimCheckThreshold  int            // payload bytes above which a message is claim-checked
+	Compression          string         // EncodingGzip or EncodingDeflate, empty stores payloads as published
+	CompressionThreshold int            // payload bytes above which the broker compresses
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		MaxQueueDepth:      10000,
-		DefaultMaxRetries:  3,
-		RetryDelay:         time.Second * 5,
-		AutoCreateTopics:   true,
-		ConsumerBufferSize: 100,
+		MaxQueueDepth:        10000,
+		DefaultMaxRetries:    3,
+		RetryDelay:           time.Second * 5,
+		AutoCreateTopics:     true,
+		ConsumerBufferSize:   100,
+		ClaimCheckThreshold:  1 << 20,
+		CompressionThreshold: 1 << 10,
 	}
-}
\ No newline at end of file
+}
+
+// topicDefaults derives the config of topics created without one
+func (c *Config) topicDefaults() TopicConfig {
+	cfg := DefaultTopicConfig()
+	cfg.MaxDepth = c.MaxQueueDepth
+	cfg.MaxRetries = c.DefaultMaxRetries
+	cfg.RetryBackoff = c.RetryDelay
+	return cfg
+}
+
+// TopicNotFoundError is returned for a missing topic, including by Publish
+// and Subscribe when AutoCreateTopics is off
+type TopicNotFoundError struct {
+	Topic string
+}
+
+func (e *TopicNotFoundError) Error() string {
+	return fmt.Sprintf("topic %s not found", e.Topic)
+}
@@ -0,0 +1,84 @@
+package lpacamq
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewWithConfigTopicDefaults(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxQueueDepth = 2
+	cfg.DefaultMaxRetries = 1
+	cfg.RetryDelay = 10 * time.Millisecond
+
+	mq, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewWithConfig failed: %v", err)
+	}
+	defer mq.Close()
+
+	mq.Publish("orders", []byte("1"))
+	mq.Publish("orders", []byte("2"))
+	if _, err := mq.Publish("orders", []byte("3")); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected MaxQueueDepth to bound the topic, got %v", err)
+	}
+
+	topic, _ := mq.GetTopic("orders")
+	got := topic.Config()
+	if got.MaxDepth != 2 || got.MaxRetries != 1 || got.RetryBackoff != 10*time.Millisecond {
+		t.Errorf("Unexpected topic config %+v", got)
+	}
+}
+
+func TestNewWithConfigAutoCreate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AutoCreateTopics = false
+	mq, _ := NewWithConfig(cfg)
+	defer mq.Close()
+
+	var notFound *TopicNotFoundError
+	if _, err := mq.Publish("orders", []byte("x")); !errors.As(err, &notFound) || notFound.Topic != "orders" {
+		t.Errorf("Expected TopicNotFoundError from Publish, got %v", err)
+	}
+	if _, err := mq.Subscribe("orders"); !errors.As(err, &notFound) {
+		t.Errorf("Expected TopicNotFoundError from Subscribe, got %v", err)
+	}
+
+	mq.CreateTopic("orders")
+	if _, err := mq.Publish("orders", []byte("x")); err != nil {
+		t.Errorf("Expected publishing to an explicit topic to work, got %v", err)
+	}
+}
+
+func TestConsumerBufferSize(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ConsumerBufferSize = 4
+	mq, _ := NewWithConfig(cfg)
+	defer mq.Close()
+
+	block := make(chan struct{})
+	var handled int32
+	consumer, _ := mq.SubscribeWithHandler("jobs", func(msg *Message) error {
+		<-block
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		mq.Publish("jobs", []byte("job"))
+	}
+
+	// One message in the handler plus a full buffer
+	time.Sleep(50 * time.Millisecond)
+	topic, _ := mq.GetTopic("jobs")
+	if topic.Len() != 5 {
+		t.Errorf("Expected 5 messages prefetched, %d left queued", topic.Len())
+	}
+
+	close(block)
+	mq.Unsubscribe(consumer.ID)
+	if n := int(atomic.LoadInt32(&handled)) + topic.Len(); n != 10 {
+		t.Errorf("Expected unhandled buffered messages returned to the topic, %d accounted for", n)
+	}
+}
@@ -14,9 +14,18 @@ type MessageHandler func(msg *Message) error
 type Consumer struct {
 	ID       string
 	Topic    string
+	Group    string // consumer group / named subscription, empty for the default queue
 	Handler  MessageHandler
 	Queue    *Queue
 
+	topic    *Topic // source of retry settings, nil for standalone consumers
+	bufferSize int // messages prefetched ahead of the handler, 0 polls one at a time
+	tracer   func() Tracer // nil for standalone consumers, which report no spans
+	rawPayload bool // hand compressed payloads to the handler as they are
+	buffer   chan *Message
+	manualAck bool // handler releases message groups itself via ack/nack
+	pinned   bool // partitions were chosen explicitly, never rebalanced
+	partitions atomic.Value // []int, nil consumes every partition
 	active   int32 // atomic
 	stopChan chan struct{}
 	wg       sync.WaitGroup
@@ -40,6 +49,12 @@ func (c *Consumer) Start() {
 		return // Already started
 	}
 
+	if c.bufferSize > 0 {
+		c.buffer = make(chan *Message, c.bufferSize)
+		c.wg.Add(1)
+		go c.prefetch()
+	}
+
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
@@ -55,7 +70,7 @@ func (c *Consumer) Start() {
 			}
 
 			// Try non-blocking pop first
-			msg, ok := c.Queue.PopNonBlocking()
+			msg, ok := c.fetch()
 			if !ok {
 				// No message available, wait a bit and retry
 				select {
@@ -67,13 +82,57 @@ func (c *Consumer) Start() {
 			}
 
 			// Process the message
-			if err := c.Handler(msg); err != nil {
-				log.Printf("[Consumer %s] Error processing message %s: %v", c.ID, msg.ID, err)
-			}
+			c.deliver(msg)
 		}
 	}()
 }
 
+// deliver runs the handler in a delivery span and releases the message
+// unless the handler acks itself, manual acks report their own span
+func (c *Consumer) deliver(msg *Message) {
+	tracer := Tracer(noopTracer{})
+	if c.tracer != nil {
+		tracer = c.tracer()
+	}
+	d := traceDelivery(tracer, msg, map[string]string{"consumer": c.ID})
+	out, err := c.hydrate(msg)
+	if err == nil {
+		out.setContext(d.context())
+		err = c.Handler(out)
+		out.setContext(nil)
+	}
+	d.delivered(err)
+
+	if err != nil {
+		log.Printf("[Consumer %s] Error processing message %s: %v", c.ID, msg.ID, err)
+		if !c.manualAck && c.retry(msg) {
+			d.settle("nack").End(err)
+			return
+		}
+	}
+	if !c.manualAck {
+		ack := d.settle("ack")
+		c.Queue.Release(msg)
+		ack.End(nil)
+	}
+}
+
+// hydrate loads a claim-checked payload and decompresses it before the
+// handler sees the message, it returns the message the handler gets
+func (c *Consumer) hydrate(msg *Message) (*Message, error) {
+	out := msg
+	if c.topic != nil {
+		var err error
+		if out, err = c.topic.hydrated(msg); err != nil {
+			return nil, err
+		}
+	}
+	if c.rawPayload {
+		return out, nil
+	}
+	return out.decompressed()
+}
+
 // Stop gracefully stops the consumer
 func (c *Consumer) Stop() {
 	// Only stop if active
@@ -88,10 +147,124 @@ func (c *Consumer) Stop() {
 		c.mu.Unlock()
 
 		c.wg.Wait()
+		c.returnBuffered()
 		log.Printf("[Consumer %s] Stopped", c.ID)
 	}
 }
 
+// Partitions returns the partitions the consumer reads, nil means all
+func (c *Consumer) Partitions() []int {
+	parts, _ := c.partitions.Load().([]int)
+	return parts
+}
+
+// assign sets the partitions the consumer reads
+func (c *Consumer) assign(parts []int) {
+	c.partitions.Store(parts)
+}
+
+// fetch hands the handler loop its next message, from the prefetch buffer
+// if the consumer has one
+func (c *Consumer) fetch() (*Message, bool) {
+	if c.buffer == nil {
+		return c.poll()
+	}
+	select {
+	case msg := <-c.buffer:
+		return msg, true
+	default:
+		return nil, false
+	}
+}
+
+// prefetch keeps the buffer filled, buffered messages count as in flight and
+// hold their group and partition locks, so ordering is unaffected
+func (c *Consumer) prefetch() {
+	defer c.wg.Done()
+
+	for {
+		// Only this loop sends, so a buffer with room never blocks the send
+		var msg *Message
+		ok := false
+		if len(c.buffer) < cap(c.buffer) {
+			msg, ok = c.poll()
+		}
+		if !ok {
+			select {
+			case <-c.stopChan:
+				return
+			case <-time.After(5 * time.Millisecond):
+				continue
+			}
+		}
+
+		select {
+		case c.buffer <- msg:
+		case <-c.stopChan:
+			c.Queue.unread(msg)
+			return
+		}
+	}
+}
+
+// returnBuffered puts prefetched messages the handler never saw back at the
+// head of the queue, called once both loops have exited
+func (c *Consumer) returnBuffered() {
+	if c.buffer == nil {
+		return
+	}
+	var msgs []*Message
+	for len(c.buffer) > 0 {
+		msgs = append(msgs, <-c.buffer)
+	}
+	c.Queue.unread(msgs...)
+}
+
+// poll takes the next message from the consumer's partitions, it stays in
+// flight until the consumer releases it
+func (c *Consumer) poll() (*Message, bool) {
+	parts := c.Partitions()
+	if parts == nil {
+		return c.Queue.checkout(nil)
+	}
+	return c.Queue.checkout(partitionSet(parts))
+}
+
+// retry schedules a failed message for redelivery after the topic's retry
+// backoff, returning false when it should be released instead. A message out
+// of retries goes to the topic's dead-letter queue. Its group and partition
+// stay locked until it is back on the queue, so ordering holds
+func (c *Consumer) retry(msg *Message) bool {
+	if c.topic == nil {
+		return false
+	}
+	cfg := c.topic.Config()
+	if cfg.MaxRetries == 0 {
+		return false
+	}
+
+	if msg.RetryCount >= cfg.MaxRetries {
+		log.Printf("[Consumer %s] Message %s dead-lettered after %d retries", c.ID, msg.ID, msg.RetryCount)
+		if err := c.topic.deadLetter(msg); err != nil {
+			log.Printf("[Consumer %s] Failed to dead-letter message %s: %v", c.ID, msg.ID, err)
+		}
+		return false
+	}
+
+	msg.RetryCount++
+	requeue := func() {
+		if err := c.Queue.redeliver(msg); err != nil {
+			log.Printf("[Consumer %s] Failed to requeue message %s: %v", c.ID, msg.ID, err)
+		}
+	}
+	if delay := cfg.RetryBackoff * time.Duration(msg.RetryCount); delay > 0 {
+		time.AfterFunc(delay, requeue)
+	} else {
+		requeue()
+	}
+	return true
+}
+
 // IsActive returns true if consumer is running
 func (c *Consumer) IsActive() bool {
 	return atomic.LoadInt32(&c.active) == 1
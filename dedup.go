@@ -0,0 +1,129 @@
+package lpacamq
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDedupWindow is how long an idempotency key is remembered
+	DefaultDedupWindow = 5 * time.Minute
+	// DefaultDedupMaxKeys caps how many idempotency keys a topic remembers
+	DefaultDedupMaxKeys = 10000
+)
+
+type dedupEntry struct {
+	key    string
+	id     string
+	seenAt time.Time
+}
+
+// dedupWindow remembers recently seen idempotency keys for a topic,
+// bounded both by age and by count
+type dedupWindow struct {
+	ttl     time.Duration
+	maxKeys int
+	entries map[string]*dedupEntry
+	order   []*dedupEntry // oldest first
+	mu      sync.Mutex
+}
+
+func newDedupWindow(ttl time.Duration, maxKeys int) *dedupWindow {
+	return &dedupWindow{
+		ttl:     ttl,
+		maxKeys: maxKeys,
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+// reserve records key for message id unless the key is already inside the
+// window, in which case the original entry is returned with dup set
+func (d *dedupWindow) reserve(key, id string, now time.Time) (orig dedupEntry, dup bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prune(now)
+	if e, ok := d.entries[key]; ok {
+		return *e, true
+	}
+	d.add(&dedupEntry{key: key, id: id, seenAt: now})
+	d.prune(now)
+	return dedupEntry{}, false
+}
+
+// restore re-inserts a key seen at the given time, used during WAL recovery
+func (d *dedupWindow) restore(key, id string, seenAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.entries[key]; ok {
+		return
+	}
+	d.add(&dedupEntry{key: key, id: id, seenAt: seenAt})
+	d.prune(time.Now())
+}
+
+// forget drops key so a failed publish can be retried with it
+func (d *dedupWindow) forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.entries[key]
+	if !ok {
+		return
+	}
+	delete(d.entries, key)
+	for i, o := range d.order {
+		if o == e {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// resize changes the window bounds, evicting anything now outside them
+func (d *dedupWindow) resize(ttl time.Duration, maxKeys int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.ttl = ttl
+	d.maxKeys = maxKeys
+	d.prune(time.Now())
+}
+
+// bounds returns the window's ttl and key limit
+func (d *dedupWindow) bounds() (time.Duration, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ttl, d.maxKeys
+}
+
+func (d *dedupWindow) len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.entries)
+}
+
+// add appends e, caller holds d.mu
+func (d *dedupWindow) add(e *dedupEntry) {
+	d.entries[e.key] = e
+	d.order = append(d.order, e)
+}
+
+// prune evicts expired keys and keys over the count limit, caller holds d.mu
+func (d *dedupWindow) prune(now time.Time) {
+	n := 0
+	for n < len(d.order) {
+		e := d.order[n]
+		expired := d.ttl > 0 && now.Sub(e.seenAt) > d.ttl
+		over := d.maxKeys > 0 && len(d.order)-n > d.maxKeys
+		if !expired && !over {
+			break
+		}
+		delete(d.entries, e.key)
+		n++
+	}
+	if n > 0 {
+		d.order = d.order[n:]
+	}
+}
@@ -0,0 +1,120 @@
+package lpacamq
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDedupWindowCount(t *testing.T) {
+	d := newDedupWindow(0, 2)
+	now := time.Now()
+
+	d.reserve("a", "id-a", now)
+	d.reserve("b", "id-b", now)
+	d.reserve("c", "id-c", now)
+
+	if d.len() != 2 {
+		t.Errorf("Expected 2 keys, got %d", d.len())
+	}
+	if _, dup := d.reserve("a", "id-a2", now); dup {
+		t.Error("Oldest key should have been evicted")
+	}
+	if orig, dup := d.reserve("c", "id-c2", now); !dup || orig.id != "id-c" {
+		t.Errorf("Expected duplicate of id-c, got %v %s", dup, orig.id)
+	}
+}
+
+func TestDedupWindowTTL(t *testing.T) {
+	d := newDedupWindow(time.Minute, 0)
+	now := time.Now()
+
+	d.reserve("a", "id-a", now)
+	if _, dup := d.reserve("a", "id-a2", now.Add(30*time.Second)); !dup {
+		t.Error("Key inside window should be a duplicate")
+	}
+	if _, dup := d.reserve("a", "id-a3", now.Add(2*time.Minute)); dup {
+		t.Error("Key outside window should not be a duplicate")
+	}
+}
+
+func TestPublishIdempotencyKey(t *testing.T) {
+	mq := New()
+
+	first, err := mq.Publish("orders", []byte("order-1"), WithIdempotencyKey("k1"))
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	second, err := mq.Publish("orders", []byte("order-1"), WithIdempotencyKey("k1"))
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if second.ID != first.ID {
+		t.Errorf("Expected original ID %s, got %s", first.ID, second.ID)
+	}
+	if second.Payload != nil {
+		t.Errorf("Duplicate should not carry the repeated payload, got %q", second.Payload)
+	}
+
+	topic, _ := mq.GetTopic("orders")
+	if topic.Len() != 1 {
+		t.Errorf("Expected 1 message, got %d", topic.Len())
+	}
+}
+
+func TestDedupRebuiltFromWAL(t *testing.T) {
+	dir := "./test_wal_dedup"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	wal, _ := NewWAL(dir)
+	mq := New()
+	if err := mq.AttachWAL(wal); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+	first, _ := mq.Publish("orders", []byte("order-1"), WithIdempotencyKey("k1"))
+	wal.Close()
+
+	// Simulate a restart
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	if err := mq2.AttachWAL(wal2); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+
+	again, err := mq2.Publish("orders", []byte("order-1"), WithIdempotencyKey("k1"))
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if again.ID != first.ID {
+		t.Errorf("Expected original ID %s after restart, got %s", first.ID, again.ID)
+	}
+}
+
+func TestDedupWindowSurvivesRestart(t *testing.T) {
+	dir := "./test_wal_dedup_window"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+	mq.CreateTopic("orders")
+	if err := mq.SetDedupWindow("orders", time.Hour, 5); err != nil {
+		t.Fatalf("SetDedupWindow failed: %v", err)
+	}
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	if err := mq2.AttachWAL(wal2); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+
+	topic, _ := mq2.GetTopic("orders")
+	if ttl, maxKeys := topic.DedupWindow(); ttl != time.Hour || maxKeys != 5 {
+		t.Errorf("Expected 1h/5 after restart, got %v/%d", ttl, maxKeys)
+	}
+}
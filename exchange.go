@@ -0,0 +1,272 @@
+package lpacamq
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// ExchangeType selects how an exchange routes messages to bound topics
+type ExchangeType string
+
+const (
+	ExchangeDirect  ExchangeType = "direct"  // routing key equals binding key
+	ExchangeFanout  ExchangeType = "fanout"  // every bound topic
+	ExchangeTopic   ExchangeType = "topic"   // routing key matches binding pattern
+	ExchangeHeaders ExchangeType = "headers" // message headers match binding headers
+)
+
+func (t ExchangeType) valid() bool {
+	switch t {
+	case ExchangeDirect, ExchangeFanout, ExchangeTopic, ExchangeHeaders:
+		return true
+	}
+	return false
+}
+
+// Binding routes messages from an exchange to a topic
+type Binding struct {
+	Exchange   string            `json:"exchange"`
+	Topic      string            `json:"topic"`
+	RoutingKey string            `json:"routing_key,omitempty"` // exact key or topic pattern
+	Headers    map[string]string `json:"headers,omitempty"`     // headers exchange only
+	MatchAll   bool              `json:"match_all,omitempty"`   // all headers must match, default any
+}
+
+func (b Binding) equal(o Binding) bool {
+	if b.Exchange != o.Exchange || b.Topic != o.Topic || b.RoutingKey != o.RoutingKey || b.MatchAll != o.MatchAll {
+		return false
+	}
+	if len(b.Headers) != len(o.Headers) {
+		return false
+	}
+	for k, v := range b.Headers {
+		if ov, ok := o.Headers[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether a message with routingKey and headers should be
+// routed through the binding on an exchange of type typ
+func (b Binding) matches(typ ExchangeType, routingKey string, headers map[string]string) bool {
+	switch typ {
+	case ExchangeFanout:
+		return true
+	case ExchangeDirect:
+		return b.RoutingKey == routingKey
+	case ExchangeTopic:
+		return MatchTopic(b.RoutingKey, routingKey)
+	case ExchangeHeaders:
+		if len(b.Headers) == 0 {
+			return b.MatchAll
+		}
+		matched := 0
+		for k, v := range b.Headers {
+			if hv, ok := headers[k]; ok && hv == v {
+				matched++
+			}
+		}
+		if b.MatchAll {
+			return matched == len(b.Headers)
+		}
+		return matched > 0
+	}
+	return false
+}
+
+// Exchange decouples producers from topic names: messages published to an
+// exchange are routed to every topic whose binding matches
+type Exchange struct {
+	Name     string
+	Type     ExchangeType
+	bindings []Binding
+	mu       sync.RWMutex
+}
+
+// Bindings returns a copy of the exchange's bindings
+func (e *Exchange) Bindings() []Binding {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]Binding(nil), e.bindings...)
+}
+
+// route returns the distinct topics a message is routed to, in binding order
+func (e *Exchange) route(routingKey string, headers map[string]string) []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var topics []string
+	for _, b := range e.bindings {
+		if seen[b.Topic] || !b.matches(e.Type, routingKey, headers) {
+			continue
+		}
+		seen[b.Topic] = true
+		topics = append(topics, b.Topic)
+	}
+	return topics
+}
+
+// DeclareExchange creates an exchange, declaring an existing exchange with
+// the same type is a no-op
+func (mq *LpacaMQ) DeclareExchange(name string, typ ExchangeType) error {
+	if name == "" {
+		return fmt.Errorf("exchange name cannot be empty")
+	}
+	if !typ.valid() {
+		return fmt.Errorf("unknown exchange type %q", typ)
+	}
+
+	mq.mu.Lock()
+	if ex, exists := mq.exchanges[name]; exists {
+		mq.mu.Unlock()
+		if ex.Type != typ {
+			return fmt.Errorf("exchange %s already exists with type %s", name, ex.Type)
+		}
+		return nil
+	}
+	mq.exchanges[name] = &Exchange{Name: name, Type: typ}
+	mq.mu.Unlock()
+
+	log.Printf("[LpacaMQ] Exchange declared: %s (%s)", name, typ)
+	return mq.writeWAL(&WALEntry{Operation: OpDeclareExchange, Exchange: name, ExchangeType: typ})
+}
+
+// DeleteExchange removes an exchange and its bindings
+func (mq *LpacaMQ) DeleteExchange(name string) error {
+	mq.mu.Lock()
+	_, exists := mq.exchanges[name]
+	delete(mq.exchanges, name)
+	mq.mu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("exchange %s not found", name)
+	}
+
+	log.Printf("[LpacaMQ] Exchange deleted: %s", name)
+	return mq.writeWAL(&WALEntry{Operation: OpDeleteExchange, Exchange: name})
+}
+
+// GetExchange gets an existing exchange
+func (mq *LpacaMQ) GetExchange(name string) (*Exchange, error) {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	ex, exists := mq.exchanges[name]
+	if !exists {
+		return nil, fmt.Errorf("exchange %s not found", name)
+	}
+	return ex, nil
+}
+
+// ListExchanges returns all exchange names, sorted
+func (mq *LpacaMQ) ListExchanges() []string {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	names := make([]string, 0, len(mq.exchanges))
+	for name := range mq.exchanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Bind adds a binding, binding the same thing twice is a no-op
+func (mq *LpacaMQ) Bind(b Binding) error {
+	if b.Topic == "" {
+		return fmt.Errorf("binding topic cannot be empty")
+	}
+	ex, err := mq.GetExchange(b.Exchange)
+	if err != nil {
+		return err
+	}
+
+	ex.mu.Lock()
+	for _, existing := range ex.bindings {
+		if existing.equal(b) {
+			ex.mu.Unlock()
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, b)
+	ex.mu.Unlock()
+
+	return mq.writeWAL(&WALEntry{Operation: OpBind, Exchange: b.Exchange, Binding: &b})
+}
+
+// Unbind removes a binding
+func (mq *LpacaMQ) Unbind(b Binding) error {
+	ex, err := mq.GetExchange(b.Exchange)
+	if err != nil {
+		return err
+	}
+
+	ex.mu.Lock()
+	found := false
+	for i, existing := range ex.bindings {
+		if existing.equal(b) {
+			ex.bindings = append(ex.bindings[:i], ex.bindings[i+1:]...)
+			found = true
+			break
+		}
+	}
+	ex.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("binding %s -> %s not found", b.Exchange, b.Topic)
+	}
+	return mq.writeWAL(&WALEntry{Operation: OpUnbind, Exchange: b.Exchange, Binding: &b})
+}
+
+// PublishToExchange routes a message through an exchange and publishes a
+// copy to every matching topic. Headers for a headers exchange are given
+// with WithHeaders. A message matching no binding is dropped and an empty
+// slice is returned
+func (mq *LpacaMQ) PublishToExchange(exchange, routingKey string, payload []byte, opts ...PublishOption) ([]*Message, error) {
+	ex, err := mq.GetExchange(exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	o := applyPublishOptions(opts)
+	topics := ex.route(routingKey, o.headers)
+	if len(topics) == 0 {
+		log.Printf("[LpacaMQ] Exchange %s: no binding for routing key %q, message dropped", exchange, routingKey)
+	}
+
+	msgs := make([]*Message, 0, len(topics))
+	for _, topic := range topics {
+		msg, err := mq.Publish(topic, payload, opts...)
+		if err != nil {
+			return msgs, fmt.Errorf("exchange %s to topic %s: %w", exchange, topic, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// replayExchange applies an exchange or binding WAL entry during recovery
+func (mq *LpacaMQ) replayExchange(entry *WALEntry) {
+	var err error
+	switch entry.Operation {
+	case OpDeclareExchange:
+		err = mq.DeclareExchange(entry.Exchange, entry.ExchangeType)
+	case OpDeleteExchange:
+		err = mq.DeleteExchange(entry.Exchange)
+	case OpBind:
+		if entry.Binding != nil {
+			err = mq.Bind(*entry.Binding)
+		}
+	case OpUnbind:
+		if entry.Binding != nil {
+			err = mq.Unbind(*entry.Binding)
+		}
+	}
+	if err != nil {
+		log.Printf("[LpacaMQ] Skipping WAL entry %d (%s): %v", entry.Sequence, entry.Operation, err)
+	}
+}
@@ -0,0 +1,92 @@
+package lpacamq
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExchangeRouting(t *testing.T) {
+	mq := New()
+
+	mq.DeclareExchange("events", ExchangeTopic)
+	mq.Bind(Binding{Exchange: "events", Topic: "eu-orders", RoutingKey: "orders.eu.*"})
+	mq.Bind(Binding{Exchange: "events", Topic: "all-orders", RoutingKey: "orders.#"})
+
+	msgs, err := mq.PublishToExchange("events", "orders.eu.created", []byte("order"))
+	if err != nil {
+		t.Fatalf("PublishToExchange failed: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Errorf("Expected 2 routed copies, got %d", len(msgs))
+	}
+
+	msgs, _ = mq.PublishToExchange("events", "orders.us.created", []byte("order"))
+	if len(msgs) != 1 || msgs[0].Topic != "all-orders" {
+		t.Errorf("Expected only all-orders, got %v", msgs)
+	}
+
+	msgs, _ = mq.PublishToExchange("events", "payments.eu", []byte("payment"))
+	if len(msgs) != 0 {
+		t.Errorf("Unroutable message should be dropped, got %d", len(msgs))
+	}
+}
+
+func TestExchangeTypes(t *testing.T) {
+	b := Binding{Topic: "t", RoutingKey: "k"}
+	if !b.matches(ExchangeDirect, "k", nil) || b.matches(ExchangeDirect, "other", nil) {
+		t.Error("Direct should match the exact key only")
+	}
+	if !b.matches(ExchangeFanout, "anything", nil) {
+		t.Error("Fanout should match everything")
+	}
+
+	any := Binding{Topic: "t", Headers: map[string]string{"format": "pdf", "type": "report"}}
+	all := any
+	all.MatchAll = true
+	headers := map[string]string{"format": "pdf"}
+	if !any.matches(ExchangeHeaders, "", headers) {
+		t.Error("Headers any should match one header")
+	}
+	if all.matches(ExchangeHeaders, "", headers) {
+		t.Error("Headers all should require every header")
+	}
+
+	mq := New()
+	if err := mq.DeclareExchange("x", "bogus"); err == nil {
+		t.Error("Unknown exchange type should fail")
+	}
+	mq.DeclareExchange("x", ExchangeDirect)
+	if err := mq.DeclareExchange("x", ExchangeFanout); err == nil {
+		t.Error("Redeclaring with another type should fail")
+	}
+}
+
+func TestExchangesRestoredFromWAL(t *testing.T) {
+	dir := "./test_wal_exchange"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+	mq.DeclareExchange("events", ExchangeDirect)
+	mq.DeclareExchange("old", ExchangeFanout)
+	mq.Bind(Binding{Exchange: "events", Topic: "orders", RoutingKey: "order"})
+	mq.Bind(Binding{Exchange: "events", Topic: "audit", RoutingKey: "order"})
+	mq.Unbind(Binding{Exchange: "events", Topic: "audit", RoutingKey: "order"})
+	mq.DeleteExchange("old")
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	mq2.AttachWAL(wal2)
+
+	if names := mq2.ListExchanges(); len(names) != 1 || names[0] != "events" {
+		t.Fatalf("Expected only events after restart, got %v", names)
+	}
+	ex, _ := mq2.GetExchange("events")
+	if bindings := ex.Bindings(); len(bindings) != 1 || bindings[0].Topic != "orders" {
+		t.Errorf("Unexpected bindings after restart: %v", bindings)
+	}
+}
@@ -0,0 +1,431 @@
+package lpacamq
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a compiled subscription filter over message headers and
+// top-level JSON payload fields, for example
+//
+//	headers.type = 'order' and (payload.amount > 100 or payload.region in ('eu', 'uk'))
+//	exists(headers.priority) and not payload.test = true
+//
+// Fields are headers.<name> or payload.<field>. Operators are = != < <= > >=,
+// in (...), exists(...), and, or, not and parentheses. Numbers compare
+// numerically, everything else as strings. A missing field never matches a
+// comparison
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// ParseFilter compiles a filter expression
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("filter: unexpected %q", p.peek().text)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// String returns the source expression
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match evaluates the filter against msg, a nil filter matches everything.
+// A compressed payload is decompressed first, a claim-checked one has no
+// fields unless it is hydrated; subscriptions see claim-checked payloads too
+func (f *Filter) Match(msg *Message) bool {
+	return f.match(msg, msg.decodedPayload)
+}
+
+// match is Match with the payload fields read from what load returns, it is
+// only called if the filter looks at the payload
+func (f *Filter) match(msg *Message, load func() ([]byte, error)) bool {
+	if f == nil {
+		return true
+	}
+	return f.root.eval(&filterEnv{msg: msg, load: load})
+}
+
+// filterEnv lazily decodes the payload once per evaluation
+type filterEnv struct {
+	msg     *Message
+	load    func() ([]byte, error)
+	payload map[string]interface{}
+	decoded bool
+}
+
+func (e *filterEnv) lookup(f field) (interface{}, bool) {
+	if f.headers {
+		v, ok := e.msg.Headers[f.name]
+		return v, ok
+	}
+	if !e.decoded {
+		e.decoded = true
+		if data, err := e.load(); err == nil {
+			json.Unmarshal(data, &e.payload) // non-JSON payloads have no fields
+		}
+	}
+	v, ok := e.payload[f.name]
+	return v, ok
+}
+
+type field struct {
+	headers bool
+	name    string
+}
+
+type filterNode interface {
+	eval(env *filterEnv) bool
+}
+
+type andNode struct{ left, right filterNode }
+type orNode struct{ left, right filterNode }
+type notNode struct{ inner filterNode }
+type existsNode struct{ field field }
+type compareNode struct {
+	field field
+	op    string
+	value interface{}
+}
+type inNode struct {
+	field  field
+	values []interface{}
+}
+
+func (n andNode) eval(env *filterEnv) bool { return n.left.eval(env) && n.right.eval(env) }
+func (n orNode) eval(env *filterEnv) bool  { return n.left.eval(env) || n.right.eval(env) }
+func (n notNode) eval(env *filterEnv) bool { return !n.inner.eval(env) }
+
+func (n existsNode) eval(env *filterEnv) bool {
+	_, ok := env.lookup(n.field)
+	return ok
+}
+
+func (n compareNode) eval(env *filterEnv) bool {
+	v, ok := env.lookup(n.field)
+	if !ok {
+		return false
+	}
+	return compareValues(v, n.op, n.value)
+}
+
+func (n inNode) eval(env *filterEnv) bool {
+	v, ok := env.lookup(n.field)
+	if !ok {
+		return false
+	}
+	for _, candidate := range n.values {
+		if compareValues(v, "=", candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareValues compares a field value with a literal, numerically when both
+// sides are numbers (header strings are parsed), otherwise as strings
+func compareValues(v interface{}, op string, literal interface{}) bool {
+	if lf, ok := literal.(float64); ok {
+		if vf, ok := toNumber(v); ok {
+			return compareOrdered(vf, lf, op)
+		}
+	}
+	return compareOrdered(toString(v), toString(literal), op)
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case nil:
+		return "null"
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func compareOrdered[T float64 | string](a, b T, op string) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokString
+	tokNumber
+	tokOp
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func lexFilter(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, token{tokPunct, string(c)})
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end < 0 {
+				return nil, fmt.Errorf("filter: unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokString, s[i+1 : i+1+end]})
+			i += end + 2
+		case strings.ContainsRune("=!<>", c):
+			j := i + 1
+			if j < len(s) && s[j] == '=' {
+				j++
+			}
+			op := s[i:j]
+			if op == "==" {
+				op = "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("filter: unexpected '!' at %d", i)
+			}
+			tokens = append(tokens, token{tokOp, op})
+			i = j
+		case c == '-' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.' || s[j] == 'e' || s[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, s[i:j]})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || strings.ContainsRune("_.-", rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, s[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("filter: unexpected %q at %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+// Parser
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *filterParser) peek() token {
+	if p.done() {
+		return token{kind: tokPunct, text: "end of filter"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keyword reports and consumes a case-insensitive keyword
+func (p *filterParser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(text string) error {
+	if t := p.next(); t.kind != tokPunct || t.text != text {
+		return fmt.Errorf("filter: expected %q, got %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.keyword("not") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterNode, error) {
+	if t := p.peek(); t.kind == tokPunct && t.text == "(" {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+
+	if p.keyword("exists") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		f, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		return existsNode{f}, p.expect(")")
+	}
+
+	f, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyword("in") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		for {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			if t := p.peek(); t.kind == tokPunct && t.text == "," {
+				p.pos++
+				continue
+			}
+			break
+		}
+		return inNode{f, values}, p.expect(")")
+	}
+
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, fmt.Errorf("filter: expected operator after %s, got %q", f.name, op.text)
+	}
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{f, op.text, v}, nil
+}
+
+func (p *filterParser) parseField() (field, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return field{}, fmt.Errorf("filter: expected field, got %q", t.text)
+	}
+	switch {
+	case strings.HasPrefix(t.text, "headers.") && len(t.text) > len("headers."):
+		return field{headers: true, name: strings.TrimPrefix(t.text, "headers.")}, nil
+	case strings.HasPrefix(t.text, "payload.") && len(t.text) > len("payload."):
+		return field{name: strings.TrimPrefix(t.text, "payload.")}, nil
+	}
+	return field{}, fmt.Errorf("filter: field %q must start with headers. or payload.", t.text)
+}
+
+func (p *filterParser) parseValue() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return t.text, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("filter: bad number %q", t.text)
+		}
+		return f, nil
+	case tokIdent:
+		switch strings.ToLower(t.text) {
+		case "true", "false", "null":
+			return strings.ToLower(t.text), nil // compared via their string form
+		}
+	}
+	return nil, fmt.Errorf("filter: expected value, got %q", t.text)
+}
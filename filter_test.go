@@ -0,0 +1,163 @@
+package lpacamq
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterMatch(t *testing.T) {
+	msg := NewMessage("orders", []byte(`{"amount": 150, "region": "eu", "test": false}`))
+	msg.Headers = map[string]string{"type": "order", "priority": "5"}
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{"headers.type = 'order'", true},
+		{"headers.type != 'order'", false},
+		{"payload.amount > 100", true},
+		{"payload.amount <= 100", false},
+		{"headers.priority >= 5", true},
+		{"payload.region in ('eu', 'uk')", true},
+		{"payload.region in ('us')", false},
+		{"exists(headers.priority) and not exists(headers.missing)", true},
+		{"payload.amount > 1000 or headers.type == \"order\"", true},
+		{"(payload.amount > 1000 or payload.region = 'us') and headers.type = 'order'", false},
+		{"payload.test = false", true},
+		{"payload.missing = 'x'", false},
+	}
+
+	for _, c := range cases {
+		f, err := ParseFilter(c.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q) failed: %v", c.expr, err)
+			continue
+		}
+		if got := f.Match(msg); got != c.want {
+			t.Errorf("%q = %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestFilterParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"type = 'order'",
+		"headers.type = ",
+		"headers.type = 'order",
+		"(headers.type = 'a'",
+		"payload.x in 1",
+		"headers.a = 1 extra",
+	} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("Expected parse error for %q", expr)
+		}
+	}
+}
+
+func TestSubscriptionFilter(t *testing.T) {
+	mq := New()
+
+	queue, err := mq.Subscribe("orders", WithGroup("big-orders"), WithFilter("payload.amount >= 100"))
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if _, err := mq.Subscribe("orders", WithFilter("payload.amount >= 100")); err == nil {
+		t.Error("Filter without a group should fail")
+	}
+
+	mq.Publish("orders", []byte(`{"amount": 50}`))
+	mq.Publish("orders", []byte(`{"amount": 500}`))
+
+	if queue.Len() != 1 {
+		t.Fatalf("Expected 1 filtered message, got %d", queue.Len())
+	}
+	msg, _ := queue.PopNonBlocking()
+	if string(msg.Payload) != `{"amount": 500}` {
+		t.Errorf("Wrong message delivered: %s", msg.Payload)
+	}
+}
+
+func TestFilterCompressedAndClaimChecked(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	mq.EnableClaimCheck(t.TempDir(), 64)
+
+	queue, err := mq.Subscribe("orders", WithGroup("big-orders"), WithFilter("payload.amount > 100"))
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	padding := strings.Repeat("x", 100)
+	compressed, _ := mq.Publish("orders", []byte(`{"amount": 500, "note": "`+padding+`"}`), WithCompression(EncodingGzip))
+	claimed, _ := mq.Publish("orders", []byte(`{"amount": 600, "note": "`+padding+`"}`))
+	mq.Publish("orders", []byte(`{"amount": 50, "note": "`+padding+`"}`))
+	if compressed.Headers[ContentEncodingHeader] != EncodingGzip || claimed.Headers[BlobRefHeader] == "" {
+		t.Fatal("Expected one compressed and one claim-checked order")
+	}
+
+	if queue.Len() != 2 {
+		t.Errorf("Expected the compressed and the claim-checked order matched, got %d", queue.Len())
+	}
+}
+
+func TestServerSubscribeFilter(t *testing.T) {
+	mq := New()
+	mq.CreateTopic("orders")
+	server := NewServer(mq, "localhost:0")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	path := "/subscribe/orders?filter=" + url.QueryEscape("headers.type = 'order'")
+	req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		server.handleSubscribe(w, req)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	mq.Publish("orders", []byte("skip"), WithHeaders(map[string]string{"type": "refund"}))
+	mq.Publish("orders", []byte("keep"), WithHeaders(map[string]string{"type": "order"}))
+	<-done
+
+	body := w.Body.String()
+	if !strings.Contains(body, `"ID"`) || strings.Count(body, "data: ") != 1 {
+		t.Errorf("Expected exactly one event, got %q", body)
+	}
+
+	topic, _ := mq.GetTopic("orders")
+	if len(topic.Subscriptions()) != 0 {
+		t.Errorf("Private subscription should be removed, got %v", topic.Subscriptions())
+	}
+}
+
+func TestGroupFilterNotReplaced(t *testing.T) {
+	mq := New()
+
+	if _, err := mq.Subscribe("orders", WithGroup("big"), WithFilter("payload.amount >= 100")); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if _, err := mq.Subscribe("orders", WithGroup("big"), WithFilter("payload.amount >= 100")); err != nil {
+		t.Errorf("Joining with the group's own filter should work: %v", err)
+	}
+	if _, err := mq.Subscribe("orders", WithGroup("big"), WithFilter("payload.amount >= 1")); err == nil {
+		t.Error("Joining with a different filter should fail")
+	}
+	if _, err := mq.Subscribe("orders", WithGroup("big")); err != nil {
+		t.Errorf("Joining without a filter should work: %v", err)
+	}
+
+	topic, _ := mq.GetTopic("orders")
+	sub, _ := topic.Subscription("big")
+	if sub.Filter().String() != "payload.amount >= 100" {
+		t.Errorf("Group filter changed to %q", sub.Filter())
+	}
+}
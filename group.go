@@ -0,0 +1,78 @@
+package lpacamq
+
+import (
+	"fmt"
+	"sort"
+)
+
+// GroupStats describes a consumer group on a topic
+type GroupStats struct {
+	Topic    string   `json:"topic"`
+	Group    string   `json:"group"`
+	Members  []string `json:"members"`
+	Backlog  int      `json:"backlog"`
+	InFlight int      `json:"in_flight"`
+}
+
+// ConsumerGroups returns stats for every consumer group on a topic
+func (mq *LpacaMQ) ConsumerGroups(topicName string) ([]GroupStats, error) {
+	topic, err := mq.GetTopic(topicName)
+	if err != nil {
+		return nil, err
+	}
+
+	names := topic.Subscriptions()
+	sort.Strings(names)
+
+	stats := make([]GroupStats, 0, len(names))
+	for _, name := range names {
+		if s, ok := mq.groupStats(topic, name); ok {
+			stats = append(stats, s)
+		}
+	}
+	return stats, nil
+}
+
+// GroupStats returns membership, backlog and in-flight counts of one group
+func (mq *LpacaMQ) GroupStats(topicName, group string) (GroupStats, error) {
+	topic, err := mq.GetTopic(topicName)
+	if err != nil {
+		return GroupStats{}, err
+	}
+
+	s, ok := mq.groupStats(topic, group)
+	if !ok {
+		return GroupStats{}, fmt.Errorf("group %s not found on topic %s", group, topicName)
+	}
+	return s, nil
+}
+
+func (mq *LpacaMQ) groupStats(topic *Topic, group string) (GroupStats, bool) {
+	sub, ok := topic.Subscription(group)
+	if !ok {
+		return GroupStats{}, false
+	}
+
+	return GroupStats{
+		Topic:    topic.Name,
+		Group:    group,
+		Members:  mq.groupMembers(topic.Name, group),
+		Backlog:  sub.queue.Len(),
+		InFlight: sub.queue.InFlight(),
+	}, true
+}
+
+// groupMembers lists the IDs of consumers in a group, sorted
+func (mq *LpacaMQ) groupMembers(topicName, group string) []string {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	members := []string{}
+	for id, c := range mq.consumers {
+		if c.Topic == topicName && c.Group == group {
+			members = append(members, id)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
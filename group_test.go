@@ -0,0 +1,72 @@
+package lpacamq
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConsumerGroups(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	var billing1, billing2, audit int32
+	count := func(n *int32) MessageHandler {
+		return func(msg *Message) error {
+			atomic.AddInt32(n, 1)
+			return nil
+		}
+	}
+
+	mq.SubscribeWithHandler("orders", count(&billing1), WithGroup("billing"))
+	mq.SubscribeWithHandler("orders", count(&billing2), WithGroup("billing"))
+	mq.SubscribeWithHandler("orders", count(&audit), WithGroup("audit"))
+
+	for i := 0; i < 20; i++ {
+		mq.Publish("orders", []byte("order"))
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	// Members of billing share the stream, audit sees all of it
+	if total := atomic.LoadInt32(&billing1) + atomic.LoadInt32(&billing2); total != 20 {
+		t.Errorf("Expected billing to process 20 in total, got %d", total)
+	}
+	if atomic.LoadInt32(&audit) != 20 {
+		t.Errorf("Expected audit to process 20, got %d", audit)
+	}
+
+	groups, err := mq.ConsumerGroups("orders")
+	if err != nil {
+		t.Fatalf("ConsumerGroups failed: %v", err)
+	}
+	if len(groups) != 2 || groups[0].Group != "audit" || groups[1].Group != "billing" {
+		t.Fatalf("Unexpected groups: %+v", groups)
+	}
+	if len(groups[1].Members) != 2 {
+		t.Errorf("Expected 2 billing members, got %v", groups[1].Members)
+	}
+	if groups[1].Backlog != 0 || groups[1].InFlight != 0 {
+		t.Errorf("Expected drained group, got backlog %d in-flight %d", groups[1].Backlog, groups[1].InFlight)
+	}
+}
+
+func TestGroupStatsBacklog(t *testing.T) {
+	mq := New()
+
+	queue, _ := mq.Subscribe("orders", WithGroup("audit"))
+	mq.Publish("orders", []byte("order-1"))
+	mq.Publish("orders", []byte("order-2"))
+	queue.checkout(nil)
+
+	stats, err := mq.GroupStats("orders", "audit")
+	if err != nil {
+		t.Fatalf("GroupStats failed: %v", err)
+	}
+	if stats.Backlog != 1 || stats.InFlight != 1 {
+		t.Errorf("Expected backlog 1 in-flight 1, got %+v", stats)
+	}
+	if _, err := mq.GroupStats("orders", "missing"); err == nil {
+		t.Error("Unknown group should fail")
+	}
+}
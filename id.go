@@ -2,50 +2,140 @@ package lpacamq
 
 import (
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
-	"sync/atomic"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
+// IDs are ULID-style: 128 bits written as 26 Crockford base32 characters,
+// so they sort lexicographically in creation order.
+// Layout: 48 bits unix ms + 16 bits node + 16 bits pid + 48 bits sequence.
+// Only the low 16 bits of the pid fit, larger pids are truncated
+const (
+	idLength   = 26
+	idAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
 var (
-	sequence uint32
-	machineID string
+	nodeID    uint16
+	processID = uint16(os.Getpid()) // low 16 bits, see the layout above
+
+	idMu     sync.Mutex
+	lastMs   uint64
+	sequence uint64 // 48 bits
 )
 
 func init() {
-	// Generate random machine ID
-	b := make([]byte, 3)
-	rand.Read(b)
-	machineID = hex.EncodeToString(b)
+	// Node from the hostname, so IDs from one host are recognisable
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		b := make([]byte, 2)
+		rand.Read(b)
+		nodeID = binary.BigEndian.Uint16(b)
+		return
+	}
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	sum := h.Sum32()
+	nodeID = uint16(sum>>16) ^ uint16(sum)
 }
 
-// GenerateID creates a unique ID (similar to MongoDB ObjectID)
-// Format: 4 bytes timestamp + 3 bytes machine + 2 bytes pid + 3 bytes counter
+// GenerateID creates a unique, time-sortable ID. Within one millisecond the
+// sequence increments from a random start, if the clock steps back the last
+// timestamp is kept so IDs stay ordered
 func GenerateID() string {
-	now := uint32(time.Now().Unix())
-	seq := atomic.AddUint32(&sequence, 1)
-	
-	id := make([]byte, 12)
-	
-	// Timestamp (4 bytes)
-	id[0] = byte(now >> 24)
-	id[1] = byte(now >> 16)
-	id[2] = byte(now >> 8)
-	id[3] = byte(now)
-	
-	// Machine ID (3 bytes)
-	copy(id[4:7], []byte(machineID))
-	
-	// Process ID (2 bytes) - simplified
-	id[7] = 0
-	id[8] = 1
-	
-	// Sequence (3 bytes)
-	id[9] = byte(seq >> 16)
-	id[10] = byte(seq >> 8)
-	id[11] = byte(seq)
-	
-	return hex.EncodeToString(id)
+	idMu.Lock()
+	ms := uint64(time.Now().UnixMilli())
+	if ms > lastMs {
+		lastMs = ms
+		var b [8]byte
+		rand.Read(b[2:])
+		sequence = binary.BigEndian.Uint64(b[:]) >> 1 // headroom to increment
+	} else {
+		sequence++
+		if sequence >= 1<<48 {
+			lastMs++ // borrow the next millisecond
+			sequence = 0
+		}
+	}
+	ms, seq := lastMs, sequence
+	idMu.Unlock()
+
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[0:8], ms<<16|uint64(nodeID))
+	binary.BigEndian.PutUint64(id[8:16], uint64(processID)<<48|seq)
+	return encodeID(id)
+}
+
+// IDInfo is what ParseID extracts from an ID
+type IDInfo struct {
+	Time     time.Time
+	Node     uint16
+	PID      uint16 // low 16 bits of the process ID, compare with uint16(pid)
+	Sequence uint64
+}
+
+// ParseID decodes an ID made by GenerateID, case-insensitively. The PID is
+// truncated to 16 bits, so processes whose pids differ by a multiple of
+// 65536 can't be told apart
+func ParseID(id string) (IDInfo, error) {
+	raw, err := decodeID(id)
+	if err != nil {
+		return IDInfo{}, err
+	}
+	hi, lo := binary.BigEndian.Uint64(raw[0:8]), binary.BigEndian.Uint64(raw[8:16])
+	return IDInfo{
+		Time:     time.UnixMilli(int64(hi >> 16)),
+		Node:     uint16(hi),
+		PID:      uint16(lo >> 48),
+		Sequence: lo & (1<<48 - 1),
+	}, nil
+}
+
+func encodeID(id [16]byte) string {
+	hi, lo := binary.BigEndian.Uint64(id[0:8]), binary.BigEndian.Uint64(id[8:16])
+	var out [idLength]byte
+	// 26 characters hold 130 bits, the top two are always zero
+	for i := idLength - 1; i >= 0; i-- {
+		out[i] = idAlphabet[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+func decodeID(s string) ([16]byte, error) {
+	var id [16]byte
+	if len(s) != idLength {
+		return id, fmt.Errorf("invalid ID %q: expected %d characters", s, idLength)
+	}
+	var hi, lo uint64
+	for i := 0; i < idLength; i++ {
+		v := strings.IndexByte(idAlphabet, upper(s[i]))
+		if v < 0 {
+			return id, fmt.Errorf("invalid ID %q: bad character %q", s, s[i])
+		}
+		if i == 0 && v > 7 {
+			return id, fmt.Errorf("invalid ID %q: overflows 128 bits", s)
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+	binary.BigEndian.PutUint64(id[0:8], hi)
+	binary.BigEndian.PutUint64(id[8:16], lo)
+	return id, nil
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
 }
 
 // GenerateTraceID for distributed tracing
@@ -53,4 +143,11 @@ func GenerateTraceID() string {
 	b := make([]byte, 16)
 	rand.Read(b)
 	return hex.EncodeToString(b)
-}
\ No newline at end of file
+}
+
+// GenerateSpanID for distributed tracing
+func GenerateSpanID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
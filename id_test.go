@@ -1,12 +1,18 @@
 package lpacamq
 
 import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestGenerateID(t *testing.T) {
-	// Test uniqueness
+	// Test uniqueness and ordering
 	ids := make(map[string]bool)
+	prev := ""
 	for i := 0; i < 10000; i++ {
 		id := GenerateID()
 		if ids[id] {
@@ -14,10 +20,69 @@ func TestGenerateID(t *testing.T) {
 		}
 		ids[id] = true
 		
-		// Check format (24 hex chars)
-		if len(id) != 24 {
+		// Check format (26 Crockford base32 chars)
+		if len(id) != 26 {
 			t.Errorf("Wrong ID length: %d", len(id))
 		}
+		if id <= prev {
+			t.Fatalf("IDs out of order: %s after %s", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestGenerateIDConcurrent(t *testing.T) {
+	const workers, perWorker = 8, 1000
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make([]string, perWorker)
+			for i := range local {
+				local[i] = GenerateID()
+			}
+			if !sort.StringsAreSorted(local) {
+				t.Error("IDs from one goroutine out of order")
+			}
+			mu.Lock()
+			for _, id := range local {
+				seen[id] = true
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(seen) != workers*perWorker {
+		t.Errorf("Expected %d unique IDs, got %d", workers*perWorker, len(seen))
+	}
+}
+
+func TestParseID(t *testing.T) {
+	before := time.Now().Truncate(time.Millisecond)
+	id := GenerateID()
+	after := time.Now()
+
+	info, err := ParseID(id)
+	if err != nil {
+		t.Fatalf("ParseID failed: %v", err)
+	}
+	if info.Time.Before(before) || info.Time.After(after) {
+		t.Errorf("Time %v not between %v and %v", info.Time, before, after)
+	}
+	if info.PID != uint16(os.Getpid()) || info.Node != nodeID {
+		t.Errorf("Unexpected node/pid %+v", info)
+	}
+	if lower, _ := ParseID(strings.ToLower(id)); lower != info {
+		t.Error("Expected ParseID to be case-insensitive")
+	}
+
+	for _, bad := range []string{"", "short", "8ZZZZZZZZZZZZZZZZZZZZZZZZZ", "01ARZ3NDEKTSV4RRFFQ69G5FAU"} {
+		if _, err := ParseID(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
 	}
 }
 
@@ -26,4 +91,4 @@ func TestGenerateTraceID(t *testing.T) {
 	if len(trace) != 32 {
 		t.Errorf("Wrong trace ID length: %d", len(trace))
 	}
-}
\ No newline at end of file
+}
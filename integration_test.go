@@ -1,6 +1,8 @@
 package lpacamq
 
 import (
+	"fmt"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -112,4 +114,50 @@ func TestIntegrationGracefulShutdown(t *testing.T) {
 		t.Error("Should have processed some messages")
 	}
 	t.Logf("Processed %d messages before shutdown", processedCount)
-}
\ No newline at end of file
+}
+func TestIntegrationMessageGroupsOrdered(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	var mu sync.Mutex
+	seen := make(map[string][]string)
+	handler := func(msg *Message) error {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		seen[msg.GroupID] = append(seen[msg.GroupID], string(msg.Payload))
+		mu.Unlock()
+		return nil
+	}
+
+	// Competing consumers must still see each group in order
+	for i := 0; i < 3; i++ {
+		if _, err := mq.SubscribeWithHandler("orders", handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		for _, group := range []string{"order-1", "order-2"} {
+			mq.Publish("orders", []byte(fmt.Sprintf("%d", i)), WithGroupID(group))
+		}
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 2 {
+		t.Fatalf("Expected both groups to be delivered, got %d", len(seen))
+	}
+	for group, payloads := range seen {
+		if len(payloads) != 10 {
+			t.Errorf("Group %s: expected 10 messages, got %d", group, len(payloads))
+		}
+		for i, p := range payloads {
+			if p != fmt.Sprintf("%d", i) {
+				t.Errorf("Group %s out of order: %v", group, payloads)
+				break
+			}
+		}
+	}
+}
@@ -4,37 +4,180 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // LpacaMQ is the main message queue engine
+// The broker returned by New is the root namespace, each namespace created
+// on it is an LpacaMQ of its own that journals through the root's WAL
 type LpacaMQ struct {
-	topics    map[string]*Topic
-	consumers map[string]*Consumer
-	mu        sync.RWMutex
+	topics      map[string]*Topic
+	consumers   map[string]*Consumer
+	patterns    map[string]*PatternSubscription
+	exchanges   map[string]*Exchange
+	namespaces  map[string]*LpacaMQ
+	bridges     map[string]*Bridge
+	replies     *replyRouter // created by the first Request
+	tracer      Tracer
+	claims      atomic.Pointer[claimCheck]  // set on the root only
+	compression atomic.Pointer[compression] // set on the root only
+	name        string                      // namespace name, empty for the root
+	parent      *LpacaMQ                    // nil for the root
+	nsConfig    NamespaceConfig
+	autoCreate  bool // Publish and Subscribe create missing topics
+	bufferSize  int  // prefetch buffer of handler consumers
+	wal         *WAL
+	mu          sync.RWMutex
 }
 
 // New creates a new LpacaMQ instance
 func New() *LpacaMQ {
 	return &LpacaMQ{
-		topics:    make(map[string]*Topic),
-		consumers: make(map[string]*Consumer),
+		topics:     make(map[string]*Topic),
+		consumers:  make(map[string]*Consumer),
+		patterns:   make(map[string]*PatternSubscription),
+		exchanges:  make(map[string]*Exchange),
+		namespaces: make(map[string]*LpacaMQ),
+		bridges:    make(map[string]*Bridge),
+		nsConfig:   DefaultNamespaceConfig(),
+		autoCreate: true,
 	}
 }
 
+// NewWithConfig creates an LpacaMQ instance whose topics and consumers follow
+// cfg, a nil cfg means DefaultConfig(). Unlike New, topics get a bounded
+// depth and failed messages are retried before being dead-lettered
+func NewWithConfig(cfg *Config) (*LpacaMQ, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	if cfg.ConsumerBufferSize < 0 {
+		return nil, fmt.Errorf("consumer buffer size cannot be negative")
+	}
+
+	nsConfig := DefaultNamespaceConfig()
+	nsConfig.TopicDefaults = cfg.topicDefaults()
+	if err := nsConfig.validate(); err != nil {
+		return nil, err
+	}
+
+	mq := New()
+	mq.nsConfig = nsConfig
+	mq.autoCreate = cfg.AutoCreateTopics
+	mq.bufferSize = cfg.ConsumerBufferSize
+	if err := mq.SetCompression(cfg.Compression, cfg.CompressionThreshold); err != nil {
+		return nil, err
+	}
+	if cfg.BlobDir != "" {
+		if err := mq.EnableClaimCheck(cfg.BlobDir, cfg.ClaimCheckThreshold); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range cfg.Bridges {
+		if _, err := mq.CreateBridge(b); err != nil {
+			mq.Close()
+			return nil, err
+		}
+	}
+	return mq, nil
+}
+
 // CreateTopic creates a new topic explicitly
 func (mq *LpacaMQ) CreateTopic(name string) error {
-	mq.mu.Lock()
-	defer mq.mu.Unlock()
+	return mq.CreateTopicWithConfig(name, mq.topicDefaults())
+}
 
+// CreatePartitionedTopic creates a topic split into n partitions
+func (mq *LpacaMQ) CreatePartitionedTopic(name string, n int) error {
+	if n < 1 {
+		return fmt.Errorf("partition count must be at least 1, got %d", n)
+	}
+
+	cfg := mq.topicDefaults()
+	cfg.Partitions = n
+	return mq.CreateTopicWithConfig(name, cfg)
+}
+
+// CreateTopicWithConfig creates a new topic with its own depth, overflow,
+// TTL, retry, retention, persistence and partition settings
+func (mq *LpacaMQ) CreateTopicWithConfig(name string, cfg TopicConfig) error {
+	if name == "" {
+		return fmt.Errorf("topic name cannot be empty")
+	}
+
+	mq.mu.Lock()
 	if _, exists := mq.topics[name]; exists {
+		mq.mu.Unlock()
 		return fmt.Errorf("topic %s already exists", name)
 	}
+	if err := mq.checkTopicQuota(); err != nil {
+		mq.mu.Unlock()
+		return err
+	}
+
+	topic, err := mq.newTopic(name, cfg)
+	if err != nil {
+		mq.mu.Unlock()
+		return err
+	}
+	mq.topics[name] = topic
+	mq.mu.Unlock()
 
-	mq.topics[name] = NewTopic(name)
-	log.Printf("[LpacaMQ] Topic created: %s", name)
+	mq.journalTopicConfig(topic)
+	log.Printf("[LpacaMQ] Topic created: %s (%d partitions)", name, topic.Partitions())
+	mq.topicCreated(topic)
 	return nil
 }
 
+// UpdateTopicConfig changes a topic's settings at runtime, see Topic.SetConfig
+func (mq *LpacaMQ) UpdateTopicConfig(name string, cfg TopicConfig) error {
+	topic, err := mq.GetTopic(name)
+	if err != nil {
+		return err
+	}
+
+	before := topic.Partitions()
+	if err := topic.SetConfig(cfg); err != nil {
+		return err
+	}
+	mq.journalTopicConfig(topic)
+
+	if topic.Partitions() != before {
+		for _, group := range mq.topicGroups(name) {
+			mq.rebalance(topic, group)
+		}
+	}
+	log.Printf("[LpacaMQ] Topic reconfigured: %s", name)
+	return nil
+}
+
+// topicGroups returns the distinct groups of the consumers on a topic
+func (mq *LpacaMQ) topicGroups(topicName string) []string {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var groups []string
+	for _, c := range mq.consumers {
+		if c.Topic == topicName && !seen[c.Group] {
+			seen[c.Group] = true
+			groups = append(groups, c.Group)
+		}
+	}
+	return groups
+}
+
+// journalTopicConfig records a topic's settings so they survive a restart
+func (mq *LpacaMQ) journalTopicConfig(topic *Topic) {
+	cfg := topic.Config()
+	entry := &WALEntry{Operation: OpTopicConfig, Topic: topic.Name, TopicConfig: &cfg}
+	if err := mq.writeWAL(entry); err != nil {
+		log.Printf("[LpacaMQ] Failed to journal config of topic %s: %v", topic.Name, err)
+	}
+}
+
 // GetTopic gets an existing topic (returns error if not found)
 func (mq *LpacaMQ) GetTopic(name string) (*Topic, error) {
 	mq.mu.RLock()
@@ -42,59 +185,269 @@ func (mq *LpacaMQ) GetTopic(name string) (*Topic, error) {
 
 	topic, exists := mq.topics[name]
 	if !exists {
-		return nil, fmt.Errorf("topic %s not found", name)
+		return nil, &TopicNotFoundError{Topic: name}
 	}
 
 	return topic, nil
 }
 
+// newTopic builds a topic wired to the broker's WAL
+func (mq *LpacaMQ) newTopic(name string, cfg TopicConfig) (*Topic, error) {
+	topic, err := NewTopicWithConfig(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	topic.journal = mq.writeWAL
+	topic.blobs = mq.BlobStore
+	topic.tracer = mq.currentTracer
+	return topic, nil
+}
+
 // getOrCreateTopic gets existing topic or creates new one (internal use)
-func (mq *LpacaMQ) getOrCreateTopic(name string) *Topic {
+func (mq *LpacaMQ) getOrCreateTopic(name string) (*Topic, error) {
 	mq.mu.Lock()
-	defer mq.mu.Unlock()
-
 	if topic, exists := mq.topics[name]; exists {
+		mq.mu.Unlock()
 		log.Printf("[LpacaMQ] Using existing topic: %s", name)
-		return topic
+		return topic, nil
+	}
+	if !mq.autoCreate {
+		mq.mu.Unlock()
+		return nil, &TopicNotFoundError{Topic: name}
+	}
+	if err := mq.checkTopicQuota(); err != nil {
+		mq.mu.Unlock()
+		return nil, err
 	}
 
 	// Auto-create
-	topic := NewTopic(name)
+	topic, err := mq.newTopic(name, mq.nsConfig.TopicDefaults)
+	if err != nil {
+		mq.mu.Unlock()
+		return nil, err
+	}
 	mq.topics[name] = topic
+	mq.mu.Unlock()
+
 	log.Printf("[LpacaMQ] Auto-created topic: %s", name)
-	return topic
+	mq.topicCreated(topic)
+	return topic, nil
 }
 
 // Publish publishes a message to a topic (auto-creates topic if needed)
-func (mq *LpacaMQ) Publish(topicName string, payload []byte) (*Message, error) {
+// With an idempotency key, a repeat inside the topic's dedup window returns
+// the original message ID and nothing new is enqueued
+func (mq *LpacaMQ) Publish(topicName string, payload []byte, opts ...PublishOption) (*Message, error) {
 	if topicName == "" {
 		return nil, fmt.Errorf("topic name cannot be empty")
 	}
 
-	topic := mq.getOrCreateTopic(topicName)
+	o := applyPublishOptions(opts)
+	topic, err := mq.getOrCreateTopic(topicName)
+	if err != nil {
+		return nil, err
+	}
 
-	msg := NewMessage(topicName, payload)
-	if err := topic.Publish(msg); err != nil {
+	msg := newPublishMessage(topicName, payload, o)
+	if orig, dup := reserveKey(topic, msg); dup {
+		return orig, nil
+	}
+
+	span := mq.tracePublish(msg)
+	err = mq.publishMessage(topic, msg, o.encoding)
+	span.End(err)
+	if err != nil {
+		if msg.IdempotencyKey != "" {
+			topic.dedup.forget(msg.IdempotencyKey)
+		}
 		return nil, err
 	}
 
 	return msg, nil
 }
 
+// newPublishMessage builds the message for a publish with options o
+func newPublishMessage(topicName string, payload []byte, o *publishOptions) *Message {
+	msg := NewMessage(topicName, payload)
+	msg.IdempotencyKey = o.idempotencyKey
+	msg.GroupID = o.groupID
+	msg.Key = o.key
+	msg.Headers = copyHeaders(o.headers)
+	delete(msg.Headers, BlobRefHeader) // only the broker's own blobs are referenced
+	if o.ttl > 0 {
+		msg.ExpiresAt = msg.Timestamp.Add(o.ttl)
+	}
+	return msg
+}
+
+// reserveKey claims msg's idempotency key in the topic's dedup window. For
+// a repeat it returns a stand-in carrying only the original message's ID
+// and publish time, and true
+func reserveKey(topic *Topic, msg *Message) (*Message, bool) {
+	if msg.IdempotencyKey == "" {
+		return nil, false
+	}
+	orig, dup := topic.dedup.reserve(msg.IdempotencyKey, msg.ID, msg.Timestamp)
+	if !dup {
+		return nil, false
+	}
+	log.Printf("[LpacaMQ] Duplicate publish to %s with key %s, returning %s", topic.Name, msg.IdempotencyKey, orig.id)
+	return &Message{
+		ID:             orig.id,
+		Topic:          topic.Name,
+		Timestamp:      orig.seenAt,
+		IdempotencyKey: msg.IdempotencyKey,
+	}, true
+}
+
+// publishMessage prepares msg and enqueues it, logging it to the WAL (if
+// attached and the topic is persistent) only once its queues have room
+func (mq *LpacaMQ) publishMessage(topic *Topic, msg *Message, encoding string) error {
+	if err := mq.prepareMessage(topic, msg, encoding); err != nil {
+		return err
+	}
+
+	record := func(journaled []*Message) *WALEntry {
+		if len(journaled) == 0 {
+			return nil
+		}
+		return &WALEntry{Operation: OpPublish, Topic: topic.Name, Message: msg}
+	}
+	if err := mq.commitBatch([]*batchItem{{topic: topic, msg: msg}}, record); err != nil {
+		mq.discardClaim(msg)
+		return err
+	}
+	return nil
+}
+
+// prepareMessage validates msg against the topic's schema, stamps the
+// schema version and the topic's TTL on it, routes it to its partition, and
+// compresses and claim-checks the payload. It runs before the message is
+// journaled, so recovery sees it as it was enqueued
+func (mq *LpacaMQ) prepareMessage(topic *Topic, msg *Message, encoding string) error {
+	if err := mq.checkMessageQuota(msg); err != nil {
+		return err
+	}
+	if err := topic.validateSchema(msg); err != nil {
+		return err
+	}
+	if err := mq.compress(msg, encoding); err != nil {
+		return err
+	}
+	if err := mq.checkClaim(msg); err != nil {
+		return err
+	}
+
+	cfg := topic.Config()
+	if expiry := msg.Timestamp.Add(cfg.TTL); cfg.TTL > 0 && (msg.ExpiresAt.IsZero() || expiry.Before(msg.ExpiresAt)) {
+		msg.ExpiresAt = expiry
+	}
+	msg.Partition = topic.route(msg.Key)
+	return nil
+}
+
+// writeWAL stamps and appends entry to the WAL, a no-op when none is attached
+// Namespaces tag the entry and write through the root
+func (mq *LpacaMQ) writeWAL(entry *WALEntry) error {
+	wal := mq.stampWAL(entry)
+	if wal == nil {
+		return nil
+	}
+	return wal.Write(entry)
+}
+
+// stampWAL prepares entry for the root's WAL and returns that WAL, nil when
+// none is attached
+func (mq *LpacaMQ) stampWAL(entry *WALEntry) *WAL {
+	if mq.parent != nil {
+		entry.Namespace = mq.name
+		return mq.parent.stampWAL(entry)
+	}
+
+	mq.mu.RLock()
+	wal := mq.wal
+	mq.mu.RUnlock()
+
+	entry.Timestamp = time.Now().UnixNano()
+	return wal
+}
+
+// AttachWAL replays wal to rebuild broker state and then logs every
+// subsequent publish, removal, ack and subscription change to it
+// Messages published but never acked or removed are put back on their topics
+func (mq *LpacaMQ) AttachWAL(wal *WAL) error {
+	if wal == nil {
+		return fmt.Errorf("wal cannot be nil")
+	}
+
+	entries, err := wal.Recover()
+	if err != nil {
+		log.Printf("[LpacaMQ] WAL recovery stopped early: %v", err)
+	}
+
+	restored := mq.replay(entries)
+
+	mq.mu.Lock()
+	mq.wal = wal
+	mq.mu.Unlock()
+	mq.journalSubscriptions()
+
+	log.Printf("[LpacaMQ] WAL attached, replayed %d entries, restored %d messages", len(entries), restored)
+	return nil
+}
+
+// SetDedupWindow configures the idempotency window of a topic, the window
+// is journaled so it survives a restart
+func (mq *LpacaMQ) SetDedupWindow(topicName string, ttl time.Duration, maxKeys int) error {
+	if ttl < 0 || maxKeys < 0 {
+		return fmt.Errorf("dedup window bounds cannot be negative")
+	}
+	topic, err := mq.GetTopic(topicName)
+	if err != nil {
+		return err
+	}
+	topic.SetDedupWindow(ttl, maxKeys)
+	return nil
+}
+
 // Subscribe subscribes to a topic and returns the queue for consuming
 // Auto-creates the topic if it doesn't exist
-func (mq *LpacaMQ) Subscribe(topicName string) (*Queue, error) {
+// WithSubscription selects a named subscription instead of the shared default queue
+func (mq *LpacaMQ) Subscribe(topicName string, opts ...SubscribeOption) (*Queue, error) {
 	if topicName == "" {
 		return nil, fmt.Errorf("topic name cannot be empty")
 	}
 
-	topic := mq.getOrCreateTopic(topicName)
-	return topic.Subscribe(), nil
+	o := applySubscribeOptions(opts)
+	topic, err := mq.getOrCreateTopic(topicName)
+	if err != nil {
+		return nil, err
+	}
+	return mq.subscriptionQueue(topic, o)
+}
+
+// subscriptionQueue resolves the queue a subscriber reads from, a filter
+// is set on a named subscription when it is created
+func (mq *LpacaMQ) subscriptionQueue(topic *Topic, o *subscribeOptions) (*Queue, error) {
+	var filter *Filter
+	if o.filter != "" {
+		if o.subscription == "" {
+			return nil, fmt.Errorf("a filter needs a named subscription or group")
+		}
+		f, err := ParseFilter(o.filter)
+		if err != nil {
+			return nil, err
+		}
+		filter = f
+	}
+
+	return topic.subscriptionQueue(o.subscription, filter, o.ephemeral)
 }
 
 // SubscribeWithHandler creates a consumer with a handler function
 // This is the main method for consuming messages
-func (mq *LpacaMQ) SubscribeWithHandler(topicName string, handler MessageHandler) (*Consumer, error) {
+// Handlers on different named subscriptions each see every message
+func (mq *LpacaMQ) SubscribeWithHandler(topicName string, handler MessageHandler, opts ...SubscribeOption) (*Consumer, error) {
 	if topicName == "" {
 		return nil, fmt.Errorf("topic name cannot be empty")
 	}
@@ -103,24 +456,54 @@ func (mq *LpacaMQ) SubscribeWithHandler(topicName string, handler MessageHandler
 		return nil, fmt.Errorf("handler cannot be nil")
 	}
 
+	o := applySubscribeOptions(opts)
+
 	// Get or create the topic first
-	topic := mq.getOrCreateTopic(topicName)
-	
+	topic, err := mq.getOrCreateTopic(topicName)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get the queue from the topic
-	queue := topic.Subscribe()
+	queue, err := mq.subscriptionQueue(topic, o)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range o.partitions {
+		if p < 0 || p >= topic.Partitions() {
+			return nil, fmt.Errorf("partition %d out of range for topic %s with %d partitions", p, topicName, topic.Partitions())
+		}
+	}
 
 	// Create consumer with the queue
-	consumerID := generateID()
+	consumerID := GenerateID()
 	consumer := NewConsumer(consumerID, topicName, handler, queue)
+	consumer.Group = o.subscription
+	consumer.topic = topic
+	consumer.bufferSize = mq.bufferSize
+	consumer.tracer = mq.currentTracer
+	consumer.rawPayload = o.raw
+	if o.partitions != nil {
+		consumer.pinned = true
+		consumer.assign(o.partitions)
+	}
 
 	// Store in consumers map
 	mq.mu.Lock()
+	if err := mq.checkConsumerQuota(); err != nil {
+		mq.mu.Unlock()
+		return nil, err
+	}
 	mq.consumers[consumerID] = consumer
 	mq.mu.Unlock()
 
+	// Hand out partitions before the consumer starts reading
+	mq.rebalance(topic, consumer.Group)
+
 	// Start the consumer
 	consumer.Start()
-	
+
 	log.Printf("[LpacaMQ] Consumer %s subscribed to topic %s", consumerID, topicName)
 	return consumer, nil
 }
@@ -141,21 +524,62 @@ func (mq *LpacaMQ) Unsubscribe(consumerID string) error {
 	}
 
 	consumer.Stop()
+	if topic, err := mq.GetTopic(consumer.Topic); err == nil {
+		mq.rebalance(topic, consumer.Group)
+	}
 	return nil
 }
 
+// rebalance reassigns a partitioned topic's partitions among the unpinned
+// consumers of one group, partitions pinned by a member are left to it
+func (mq *LpacaMQ) rebalance(topic *Topic, group string) {
+	n := topic.Partitions()
+	if n <= 1 {
+		return
+	}
+
+	mq.mu.RLock()
+	var ids []string
+	members := make(map[string]*Consumer)
+	pinned := make(map[int]bool)
+	for id, c := range mq.consumers {
+		if c.Topic != topic.Name || c.Group != group {
+			continue
+		}
+		if c.pinned {
+			for _, p := range c.Partitions() {
+				pinned[p] = true
+			}
+			continue
+		}
+		ids = append(ids, id)
+		members[id] = c
+	}
+	mq.mu.RUnlock()
+
+	for id, parts := range assignPartitions(n, ids, pinned) {
+		members[id].assign(parts)
+	}
+}
+
 // DeleteTopic removes a topic
 func (mq *LpacaMQ) DeleteTopic(name string) error {
 	mq.mu.Lock()
-	defer mq.mu.Unlock()
-
 	topic, exists := mq.topics[name]
 	if !exists {
+		mq.mu.Unlock()
 		return fmt.Errorf("topic %s not found", name)
 	}
+	delete(mq.topics, name)
+	mq.mu.Unlock()
 
+	if err := mq.writeWAL(&WALEntry{Operation: OpDeleteTopic, Topic: name}); err != nil {
+		log.Printf("[LpacaMQ] Failed to journal deletion of topic %s: %v", name, err)
+	}
+	mq.topicDeleted(topic)
+	// Closing takes the queue locks, which are held while journaling, and
+	// journaling takes mq.mu, so close outside it
 	topic.Close()
-	delete(mq.topics, name)
 	log.Printf("[LpacaMQ] Topic deleted: %s", name)
 	return nil
 }
@@ -183,6 +607,18 @@ func (mq *LpacaMQ) GetConsumerCount() int {
 func (mq *LpacaMQ) Close() {
 	log.Println("[LpacaMQ] Shutting down...")
 
+	// Namespaces shut down with the root
+	mq.mu.Lock()
+	namespaces := make([]*LpacaMQ, 0, len(mq.namespaces))
+	for _, ns := range mq.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	mq.mu.Unlock()
+
+	for _, ns := range namespaces {
+		ns.Close()
+	}
+
 	// Stop all consumers first
 	mq.mu.Lock()
 	consumers := make([]*Consumer, 0, len(mq.consumers))
@@ -194,6 +630,7 @@ func (mq *LpacaMQ) Close() {
 	for _, c := range consumers {
 		c.Stop()
 	}
+	mq.stopReplies()
 
 	// Close all topics
 	mq.mu.Lock()
@@ -208,4 +645,4 @@ func (mq *LpacaMQ) Close() {
 	}
 
 	log.Println("[LpacaMQ] Shutdown complete")
-}
\ No newline at end of file
+}
@@ -1,6 +1,7 @@
 package lpacamq
 
 import (
+	"context"
 	"time"
 	"sync"
 )
@@ -12,23 +13,107 @@ type Message struct {
 	Payload	[]byte
 	Timestamp time.Time
 	RetryCount int
+	ReceiveCount int `json:",omitempty"` // times handed out via Receive
+	IdempotencyKey string `json:",omitempty"`
+	GroupID	string `json:",omitempty"` // delivered in order, one at a time per group
+	Key		string `json:",omitempty"` // partition routing key
+	Headers	map[string]string `json:",omitempty"`
+	Partition int `json:",omitempty"`
+	ExpiresAt time.Time `json:",omitzero"` // zero never expires
+	ctx		context.Context // delivery context, set by the consumer
+	origin	*Message // queued message this delivery copy was made from
 	mu		sync.RWMutex
 }
 
 // NewMessage creates a new message with the given topic and payload
 func NewMessage(topic string, payload []byte) *Message {
 	return &Message{
-		ID:        generateID(),
+		ID:        GenerateID(),
 		Topic:     topic,
 		Payload:   payload,
 		Timestamp: time.Now(),
 	}
 }
 
-var idCounter int64 = 0
+// clone copies the message for delivery to another subscription, delivery
+// state such as ReceiveCount starts afresh
+func (m *Message) clone() *Message {
+	return &Message{
+		ID:             m.ID,
+		Topic:          m.Topic,
+		Payload:        m.Payload,
+		Timestamp:      m.Timestamp,
+		RetryCount:     m.RetryCount,
+		IdempotencyKey: m.IdempotencyKey,
+		GroupID:        m.GroupID,
+		Key:            m.Key,
+		Partition:      m.Partition,
+		Headers:        m.Headers,
+		ExpiresAt:      m.ExpiresAt,
+	}
+}
+
+// forDelivery returns a copy of a queued message whose payload can be
+// rewritten for one delivery, a delivery copy is returned as it is.
+// Releasing and redelivering still go through the queued message
+func (m *Message) forDelivery() *Message {
+	if m.origin != nil {
+		return m
+	}
+	out := m.clone()
+	out.ReceiveCount = m.ReceiveCount
+	out.origin = m
+	return out
+}
+
+// queued returns the queued message a delivery copy was made from
+func (m *Message) queued() *Message {
+	if m.origin != nil {
+		return m.origin
+	}
+	return m
+}
+
+// copyHeaders returns a copy of headers so callers can't change a message
+// after publishing, nil stays nil
+func copyHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	out := make(map[string]string, len(headers))
+	for k, v := range headers {
+		out[k] = v
+	}
+	return out
+}
+
+// expired reports whether the message's TTL has run out
+func (m *Message) expired(now time.Time) bool {
+	return !m.ExpiresAt.IsZero() && now.After(m.ExpiresAt)
+}
+
+// MessageFilter selects messages, e.g. for removal
+type MessageFilter func(*Message) bool
+
+// OlderThan matches messages published more than age ago
+func OlderThan(age time.Duration) MessageFilter {
+	cutoff := time.Now().Add(-age)
+	return func(msg *Message) bool {
+		return msg.Timestamp.Before(cutoff)
+	}
+}
+
+// HasHeader matches messages whose header name equals value
+func HasHeader(name, value string) MessageFilter {
+	return func(msg *Message) bool {
+		v, ok := msg.Headers[name]
+		return ok && v == value
+	}
+}
 
-// generateID generates a unique ID for the message
-func generateID() string {
-	idCounter++
-	return time.Now().Format("20060102150405.000000000") + "-" + string(rune(idCounter))
-}
\ No newline at end of file
+// InGroup matches messages of a FIFO group
+func InGroup(group string) MessageFilter {
+	return func(msg *Message) bool {
+		return msg.GroupID == group
+	}
+}
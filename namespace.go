@@ -0,0 +1,223 @@
+package lpacamq
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// ErrQuotaExceeded is returned when a namespace quota would be exceeded
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// NamespaceConfig holds a namespace's topic defaults and quotas, a zero
+// quota is unlimited
+type NamespaceConfig struct {
+	TopicDefaults  TopicConfig // used by CreateTopic and for auto-created topics
+	MaxTopics      int
+	MaxConsumers   int
+	MaxMessageSize int // payload bytes
+}
+
+// DefaultNamespaceConfig returns unlimited quotas and the default topic config
+func DefaultNamespaceConfig() NamespaceConfig {
+	return NamespaceConfig{TopicDefaults: DefaultTopicConfig()}
+}
+
+func (c *NamespaceConfig) validate() error {
+	if err := c.TopicDefaults.validate(); err != nil {
+		return fmt.Errorf("topic defaults: %w", err)
+	}
+	if c.MaxTopics < 0 || c.MaxConsumers < 0 || c.MaxMessageSize < 0 {
+		return fmt.Errorf("quotas cannot be negative")
+	}
+	return nil
+}
+
+// CreateNamespace adds an isolated namespace (vhost) with its own topics,
+// consumers, exchanges, topic defaults and quotas. Topic names only have to
+// be unique within a namespace. Auto-creation and consumer buffering follow
+// the root
+func (mq *LpacaMQ) CreateNamespace(name string, cfg NamespaceConfig) (*LpacaMQ, error) {
+	if mq.parent != nil {
+		return nil, fmt.Errorf("namespaces cannot be nested")
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid namespace name %q", name)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	mq.mu.Lock()
+	if _, exists := mq.namespaces[name]; exists {
+		mq.mu.Unlock()
+		return nil, fmt.Errorf("namespace %s already exists", name)
+	}
+	ns := New()
+	ns.name = name
+	ns.parent = mq
+	ns.nsConfig = cfg
+	ns.autoCreate = mq.autoCreate
+	ns.bufferSize = mq.bufferSize
+	mq.namespaces[name] = ns
+	mq.mu.Unlock()
+
+	mq.journalNamespace(OpDeclareNamespace, name, &cfg)
+	log.Printf("[LpacaMQ] Namespace created: %s", name)
+	return ns, nil
+}
+
+// Namespace returns an existing namespace
+func (mq *LpacaMQ) Namespace(name string) (*LpacaMQ, error) {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	ns, exists := mq.namespaces[name]
+	if !exists {
+		return nil, fmt.Errorf("namespace %s not found", name)
+	}
+	return ns, nil
+}
+
+// NamespaceName returns the name of the namespace, empty for the root
+func (mq *LpacaMQ) NamespaceName() string {
+	return mq.name
+}
+
+// NamespaceConfig returns the namespace's topic defaults and quotas
+func (mq *LpacaMQ) NamespaceConfig() NamespaceConfig {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	return mq.nsConfig
+}
+
+// UpdateNamespace replaces a namespace's topic defaults and quotas, existing
+// topics keep their config and quotas only apply to new topics, consumers
+// and messages
+func (mq *LpacaMQ) UpdateNamespace(name string, cfg NamespaceConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	ns, err := mq.Namespace(name)
+	if err != nil {
+		return err
+	}
+
+	ns.mu.Lock()
+	ns.nsConfig = cfg
+	ns.mu.Unlock()
+
+	mq.journalNamespace(OpDeclareNamespace, name, &cfg)
+	return nil
+}
+
+// DeleteNamespace shuts down a namespace and everything in it
+func (mq *LpacaMQ) DeleteNamespace(name string) error {
+	mq.mu.Lock()
+	ns, exists := mq.namespaces[name]
+	delete(mq.namespaces, name)
+	mq.mu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("namespace %s not found", name)
+	}
+
+	ns.Close()
+	mq.journalNamespace(OpDeleteNamespace, name, nil)
+	log.Printf("[LpacaMQ] Namespace deleted: %s", name)
+	return nil
+}
+
+// ListNamespaces returns the namespace names in order
+func (mq *LpacaMQ) ListNamespaces() []string {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	names := make([]string, 0, len(mq.namespaces))
+	for name := range mq.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (mq *LpacaMQ) journalNamespace(op, name string, cfg *NamespaceConfig) {
+	entry := &WALEntry{Operation: op, Namespace: name, NamespaceConfig: cfg}
+	if err := mq.writeWAL(entry); err != nil {
+		log.Printf("[LpacaMQ] Failed to journal %s of namespace %s: %v", op, name, err)
+	}
+}
+
+// topicDefaults returns the config for topics created without one
+func (mq *LpacaMQ) topicDefaults() TopicConfig {
+	return mq.NamespaceConfig().TopicDefaults
+}
+
+// checkTopicQuota fails if one more topic would exceed MaxTopics, caller holds mq.mu
+func (mq *LpacaMQ) checkTopicQuota() error {
+	if max := mq.nsConfig.MaxTopics; max > 0 && len(mq.topics) >= max {
+		return fmt.Errorf("%s: max %d topics: %w", mq.label(), max, ErrQuotaExceeded)
+	}
+	return nil
+}
+
+// checkConsumerQuota fails if one more consumer would exceed MaxConsumers,
+// caller holds mq.mu
+func (mq *LpacaMQ) checkConsumerQuota() error {
+	if max := mq.nsConfig.MaxConsumers; max > 0 && len(mq.consumers) >= max {
+		return fmt.Errorf("%s: max %d consumers: %w", mq.label(), max, ErrQuotaExceeded)
+	}
+	return nil
+}
+
+// checkMessageQuota fails if msg is larger than MaxMessageSize
+func (mq *LpacaMQ) checkMessageQuota(msg *Message) error {
+	if max := mq.NamespaceConfig().MaxMessageSize; max > 0 && len(msg.Payload) > max {
+		return fmt.Errorf("%s: message of %d bytes over the %d byte limit: %w", mq.label(), len(msg.Payload), max, ErrQuotaExceeded)
+	}
+	return nil
+}
+
+func (mq *LpacaMQ) label() string {
+	if mq.name == "" {
+		return "root namespace"
+	}
+	return "namespace " + mq.name
+}
+
+// replayNamespaces recreates namespaces from the WAL and replays their
+// entries into them, returning the root's own entries
+func (mq *LpacaMQ) replayNamespaces(entries []*WALEntry) ([]*WALEntry, int) {
+	var own []*WALEntry
+	nested := make(map[string][]*WALEntry)
+	for _, entry := range entries {
+		switch {
+		case entry.Operation == OpDeclareNamespace && entry.NamespaceConfig != nil:
+			if _, err := mq.Namespace(entry.Namespace); err == nil {
+				mq.UpdateNamespace(entry.Namespace, *entry.NamespaceConfig)
+			} else if _, err := mq.CreateNamespace(entry.Namespace, *entry.NamespaceConfig); err != nil {
+				log.Printf("[LpacaMQ] Dropping bad namespace %s: %v", entry.Namespace, err)
+			}
+		case entry.Operation == OpDeleteNamespace:
+			mq.DeleteNamespace(entry.Namespace)
+			delete(nested, entry.Namespace)
+		case entry.Namespace != "":
+			nested[entry.Namespace] = append(nested[entry.Namespace], entry)
+		default:
+			own = append(own, entry)
+		}
+	}
+
+	restored := 0
+	for name, list := range nested {
+		ns, err := mq.Namespace(name)
+		if err != nil {
+			log.Printf("[LpacaMQ] Dropping %d entries of unknown namespace %s", len(list), name)
+			continue
+		}
+		restored += ns.replay(list)
+	}
+	return own, restored
+}
@@ -0,0 +1,114 @@
+package lpacamq
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNamespaceIsolation(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	teamA, err := mq.CreateNamespace("team-a", DefaultNamespaceConfig())
+	if err != nil {
+		t.Fatalf("CreateNamespace failed: %v", err)
+	}
+	teamB, _ := mq.CreateNamespace("team-b", DefaultNamespaceConfig())
+
+	teamA.Publish("orders", []byte("a-order"))
+	teamB.Publish("orders", []byte("b-order"))
+	teamB.Publish("orders", []byte("b-order"))
+
+	topicA, _ := teamA.GetTopic("orders")
+	topicB, _ := teamB.GetTopic("orders")
+	if topicA == topicB || topicA.Len() != 1 || topicB.Len() != 2 {
+		t.Errorf("Expected separate orders topics, got depths %d and %d", topicA.Len(), topicB.Len())
+	}
+	if _, err := mq.GetTopic("orders"); err == nil {
+		t.Error("Expected no orders topic in the root namespace")
+	}
+
+	if _, err := teamA.CreateNamespace("nested", DefaultNamespaceConfig()); err == nil {
+		t.Error("Expected nested namespaces to be refused")
+	}
+
+	mq.DeleteNamespace("team-a")
+	if _, err := mq.Namespace("team-a"); err == nil {
+		t.Error("Expected team-a to be gone")
+	}
+	if names := mq.ListNamespaces(); len(names) != 1 || names[0] != "team-b" {
+		t.Errorf("Expected only team-b left, got %v", names)
+	}
+}
+
+func TestNamespaceQuotas(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	cfg := DefaultNamespaceConfig()
+	cfg.MaxTopics = 1
+	cfg.MaxConsumers = 1
+	cfg.MaxMessageSize = 8
+	cfg.TopicDefaults.MaxRetries = 3
+	ns, _ := mq.CreateNamespace("small", cfg)
+
+	if _, err := ns.Publish("orders", []byte("ok")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if _, err := ns.Publish("other", []byte("ok")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected the topic quota to apply, got %v", err)
+	}
+	if _, err := ns.Publish("orders", []byte(strings.Repeat("x", 9))); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected the message size quota to apply, got %v", err)
+	}
+
+	handler := func(msg *Message) error { return nil }
+	if _, err := ns.SubscribeWithHandler("orders", handler); err != nil {
+		t.Fatalf("SubscribeWithHandler failed: %v", err)
+	}
+	if _, err := ns.SubscribeWithHandler("orders", handler); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected the consumer quota to apply, got %v", err)
+	}
+
+	topic, _ := ns.GetTopic("orders")
+	if topic.Config().MaxRetries != 3 {
+		t.Errorf("Expected the namespace topic defaults, got %+v", topic.Config())
+	}
+}
+
+func TestNamespaceRecovery(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+
+	mq := New()
+	mq.AttachWAL(wal)
+	ns, _ := mq.CreateNamespace("tenant", DefaultNamespaceConfig())
+	ns.Publish("orders", []byte("tenant-order"))
+	mq.Publish("orders", []byte("root-order"))
+	mq.CreateNamespace("gone", DefaultNamespaceConfig())
+	mq.DeleteNamespace("gone")
+	wal.Close()
+
+	wal, _ = NewWAL(dir)
+	defer wal.Close()
+	restarted := New()
+	if err := restarted.AttachWAL(wal); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+
+	if names := restarted.ListNamespaces(); len(names) != 1 || names[0] != "tenant" {
+		t.Fatalf("Expected only tenant restored, got %v", names)
+	}
+	tenant, _ := restarted.Namespace("tenant")
+	topic, err := tenant.GetTopic("orders")
+	if err != nil || topic.Len() != 1 {
+		t.Fatalf("Expected the tenant's message restored")
+	}
+	if msg, _ := topic.Peek(); string(msg.Payload) != "tenant-order" {
+		t.Errorf("Expected tenant-order, got %s", msg.Payload)
+	}
+	if root, _ := restarted.GetTopic("orders"); root.Len() != 1 {
+		t.Errorf("Expected the root's message restored separately")
+	}
+}
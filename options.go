@@ -0,0 +1,137 @@
+package lpacamq
+
+import "time"
+
+// PublishOption customises a single Publish call
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	idempotencyKey string
+	groupID        string
+	key            string
+	headers        map[string]string
+	ttl            time.Duration
+	encoding       string
+}
+
+func applyPublishOptions(opts []PublishOption) *publishOptions {
+	o := &publishOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithIdempotencyKey makes a retried publish with the same key inside the
+// topic's dedup window return the original message instead of a copy
+func WithIdempotencyKey(key string) PublishOption {
+	return func(o *publishOptions) {
+		o.idempotencyKey = key
+	}
+}
+
+// WithGroupID places the message in a FIFO group: messages of one group are
+// delivered in order with at most one in flight, different groups in parallel
+func WithGroupID(group string) PublishOption {
+	return func(o *publishOptions) {
+		o.groupID = group
+	}
+}
+
+// WithKey sets the partition key: on a partitioned topic every message with
+// the same key goes to the same partition, unkeyed messages are round-robined
+func WithKey(key string) PublishOption {
+	return func(o *publishOptions) {
+		o.key = key
+	}
+}
+
+// WithHeaders sets message headers, used by headers exchanges for routing
+func WithHeaders(headers map[string]string) PublishOption {
+	return func(o *publishOptions) {
+		o.headers = headers
+	}
+}
+
+// WithTTL expires the message if it is not delivered within ttl, the topic's
+// own TTL still applies if it is shorter
+func WithTTL(ttl time.Duration) PublishOption {
+	return func(o *publishOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithCompression compresses the payload with encoding (EncodingGzip or
+// EncodingDeflate) whatever its size, unless that wouldn't shrink it
+func WithCompression(encoding string) PublishOption {
+	return func(o *publishOptions) {
+		o.encoding = encoding
+	}
+}
+
+// SubscribeOption customises Subscribe and SubscribeWithHandler
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	subscription string
+	partitions   []int
+	filter       string
+	raw          bool
+	ephemeral    bool
+}
+
+func applySubscribeOptions(opts []SubscribeOption) *subscribeOptions {
+	o := &subscribeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithSubscription attaches to a named subscription, creating it if needed.
+// Each named subscription receives its own copy of every message, consumers
+// sharing one subscription compete for its messages
+func WithSubscription(name string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.subscription = name
+	}
+}
+
+// WithGroup joins a consumer group, it is an alias of WithSubscription: a
+// group is the named subscription of the same name, its members share its
+// messages while every group on the topic receives the full stream
+func WithGroup(name string) SubscribeOption {
+	return WithSubscription(name)
+}
+
+// WithPartitions pins a handler consumer to the given partitions instead of
+// having partitions assigned to it
+func WithPartitions(partitions ...int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.partitions = append([]int{}, partitions...)
+	}
+}
+
+// WithFilter sets a filter expression (see ParseFilter) on the named
+// subscription or group, so only matching messages are delivered to it
+func WithFilter(expr string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.filter = expr
+	}
+}
+
+// ephemeral marks a generated subscription as private to one subscriber:
+// it is never journaled, so a crash can't leave it behind without a consumer
+func ephemeral() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.ephemeral = true
+	}
+}
+
+// WithRawPayload hands compressed payloads to the handler as published,
+// with their content-encoding header, instead of decompressing them
+func WithRawPayload() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.raw = true
+	}
+}
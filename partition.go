@@ -0,0 +1,69 @@
+package lpacamq
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync/atomic"
+)
+
+// partitionRouter picks a partition for each published message: keyed
+// messages go to hash(key) % n so a key always lands on the same partition,
+// unkeyed messages are spread round-robin
+type partitionRouter struct {
+	n    int
+	next uint32 // atomic round-robin counter
+}
+
+func newPartitionRouter(n int) *partitionRouter {
+	if n < 1 {
+		n = 1
+	}
+	return &partitionRouter{n: n}
+}
+
+func (r *partitionRouter) route(key string) int {
+	if r.n == 1 {
+		return 0
+	}
+	if key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return int(h.Sum32() % uint32(r.n))
+	}
+	return int((atomic.AddUint32(&r.next, 1) - 1) % uint32(r.n))
+}
+
+// assignPartitions spreads partitions over consumers by range assignment,
+// skipping any partition in pinned. Consumers are sorted by ID so the
+// result is stable for the same membership
+func assignPartitions(n int, consumerIDs []string, pinned map[int]bool) map[string][]int {
+	ids := append([]string(nil), consumerIDs...)
+	sort.Strings(ids)
+
+	free := make([]int, 0, n)
+	for p := 0; p < n; p++ {
+		if !pinned[p] {
+			free = append(free, p)
+		}
+	}
+
+	out := make(map[string][]int, len(ids))
+	for _, id := range ids {
+		out[id] = []int{}
+	}
+	if len(ids) == 0 {
+		return out
+	}
+
+	per, extra := len(free)/len(ids), len(free)%len(ids)
+	start := 0
+	for i, id := range ids {
+		count := per
+		if i < extra {
+			count++
+		}
+		out[id] = append(out[id], free[start:start+count]...)
+		start += count
+	}
+	return out
+}
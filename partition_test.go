@@ -0,0 +1,164 @@
+package lpacamq
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPartitionRouter(t *testing.T) {
+	r := newPartitionRouter(3)
+
+	p := r.route("customer-42")
+	for i := 0; i < 10; i++ {
+		if r.route("customer-42") != p {
+			t.Fatal("Same key should always route to the same partition")
+		}
+	}
+
+	seen := make(map[int]int)
+	for i := 0; i < 9; i++ {
+		seen[r.route("")]++
+	}
+	for p := 0; p < 3; p++ {
+		if seen[p] != 3 {
+			t.Errorf("Round-robin should spread evenly, got %v", seen)
+			break
+		}
+	}
+}
+
+func TestAssignPartitions(t *testing.T) {
+	got := assignPartitions(5, []string{"b", "a"}, map[int]bool{4: true})
+	if fmt.Sprint(got["a"]) != "[0 1]" || fmt.Sprint(got["b"]) != "[2 3]" {
+		t.Errorf("Unexpected assignment: %v", got)
+	}
+
+	got = assignPartitions(1, []string{"a", "b"}, nil)
+	if len(got["a"]) != 1 || len(got["b"]) != 0 {
+		t.Errorf("Extra consumers should get nothing: %v", got)
+	}
+}
+
+func TestPartitionedTopicOrdering(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	if err := mq.CreatePartitionedTopic("orders", 3); err != nil {
+		t.Fatalf("CreatePartitionedTopic failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	perPartition := make(map[int][]int)
+	handler := func(msg *Message) error {
+		time.Sleep(time.Millisecond)
+		var n int
+		fmt.Sscanf(string(msg.Payload), "%d", &n)
+		mu.Lock()
+		perPartition[msg.Partition] = append(perPartition[msg.Partition], n)
+		mu.Unlock()
+		return nil
+	}
+
+	c1, _ := mq.SubscribeWithHandler("orders", handler)
+	c2, _ := mq.SubscribeWithHandler("orders", handler)
+	if len(c1.Partitions())+len(c2.Partitions()) != 3 {
+		t.Errorf("Expected all 3 partitions assigned, got %v and %v", c1.Partitions(), c2.Partitions())
+	}
+
+	for i := 0; i < 30; i++ {
+		mq.Publish("orders", []byte(fmt.Sprintf("%d", i)))
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	total := 0
+	for p, seq := range perPartition {
+		total += len(seq)
+		for i := 1; i < len(seq); i++ {
+			if seq[i] < seq[i-1] {
+				t.Errorf("Partition %d out of order: %v", p, seq)
+				break
+			}
+		}
+	}
+	if total != 30 {
+		t.Errorf("Expected 30 messages, got %d", total)
+	}
+}
+
+func TestPinnedPartitions(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	mq.CreatePartitionedTopic("events", 2)
+
+	if _, err := mq.SubscribeWithHandler("events", func(*Message) error { return nil }, WithPartitions(5)); err == nil {
+		t.Error("Out-of-range partition should fail")
+	}
+
+	var mu sync.Mutex
+	var seen []int
+	pinned, _ := mq.SubscribeWithHandler("events", func(msg *Message) error {
+		mu.Lock()
+		seen = append(seen, msg.Partition)
+		mu.Unlock()
+		return nil
+	}, WithPartitions(1))
+
+	for i := 0; i < 10; i++ {
+		mq.Publish("events", []byte("e"), WithKey(fmt.Sprintf("k%d", i)))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if fmt.Sprint(pinned.Partitions()) != "[1]" {
+		t.Errorf("Pinned consumer should keep partition 1, got %v", pinned.Partitions())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range seen {
+		if p != 1 {
+			t.Fatalf("Pinned consumer received partition %d", p)
+		}
+	}
+}
+
+func TestPartitionsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+	mq.CreatePartitionedTopic("orders", 4)
+	topic, _ := mq.GetTopic("orders")
+	topic.Subscribe()
+
+	for i := 0; i < 4; i++ {
+		mq.Publish("orders", []byte(fmt.Sprintf("%d", i)))
+	}
+	tx, _ := mq.BeginTx()
+	tx.Publish("orders", []byte("tx"))
+	tx.Commit()
+
+	var before []int
+	for _, msg := range topic.Browse(0, 0) {
+		before = append(before, msg.Partition)
+	}
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	if err := mq2.AttachWAL(wal2); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+	topic2, _ := mq2.GetTopic("orders")
+	var after []int
+	for _, msg := range topic2.Browse(0, 0) {
+		after = append(after, msg.Partition)
+	}
+	if fmt.Sprint(before) != "[0 1 2 3 0]" || fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("Expected partitions [0 1 2 3 0] kept across restart, got %v then %v", before, after)
+	}
+}
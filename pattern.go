@@ -0,0 +1,218 @@
+package lpacamq
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IsTopicPattern reports whether name contains subscription wildcards
+func IsTopicPattern(name string) bool {
+	for _, seg := range strings.Split(name, ".") {
+		if seg == "*" || seg == "#" || seg == ">" {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTopic reports whether a dot-separated topic name matches pattern.
+// "*" matches exactly one segment, "#" matches zero or more segments and
+// ">" matches one or more trailing segments, so "orders.*.created" matches
+// "orders.eu.created" and "orders.>" matches "orders.eu.created" but not "orders"
+func MatchTopic(pattern, topic string) bool {
+	return matchSegments(strings.Split(pattern, "."), strings.Split(topic, "."))
+}
+
+func matchSegments(pattern, topic []string) bool {
+	if len(pattern) == 0 {
+		return len(topic) == 0
+	}
+
+	switch pattern[0] {
+	case ">":
+		return len(pattern) == 1 && len(topic) > 0
+	case "#":
+		for i := 0; i <= len(topic); i++ {
+			if matchSegments(pattern[1:], topic[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(topic) == 0 {
+		return false
+	}
+	if pattern[0] != "*" && pattern[0] != topic[0] {
+		return false
+	}
+	return matchSegments(pattern[1:], topic[1:])
+}
+
+// PatternSubscription fans messages from every topic matching a pattern,
+// including topics created later, into a single handler
+type PatternSubscription struct {
+	ID      string
+	Pattern string
+
+	handler   MessageHandler
+	opts      []SubscribeOption
+	group     string               // subscription used on every matched topic
+	ownsGroup bool                 // group was generated, delete it on unsubscribe
+	consumers map[string]*Consumer // topic name -> consumer
+	mu        sync.Mutex
+}
+
+// Topics returns the names of the topics currently matched
+func (ps *PatternSubscription) Topics() []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	names := make([]string, 0, len(ps.consumers))
+	for name := range ps.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SubscribePattern delivers messages from every topic matching pattern to
+// handler. Each matched topic gets its own named subscription so the pattern
+// subscriber sees every message, WithGroup shares one across subscribers
+func (mq *LpacaMQ) SubscribePattern(pattern string, handler MessageHandler, opts ...SubscribeOption) (*PatternSubscription, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern cannot be empty")
+	}
+	if handler == nil {
+		return nil, fmt.Errorf("handler cannot be nil")
+	}
+
+	o := applySubscribeOptions(opts)
+	ps := &PatternSubscription{
+		ID:        GenerateID(),
+		Pattern:   pattern,
+		handler:   handler,
+		opts:      opts,
+		group:     o.subscription,
+		consumers: make(map[string]*Consumer),
+	}
+	if ps.group == "" {
+		ps.group = "pattern-" + ps.ID
+		ps.ownsGroup = true
+		ps.opts = append(ps.opts, WithGroup(ps.group), ephemeral())
+	}
+
+	// Register first so topics created meanwhile are picked up too
+	mq.mu.Lock()
+	mq.patterns[ps.ID] = ps
+	topics := make([]*Topic, 0, len(mq.topics))
+	for _, t := range mq.topics {
+		topics = append(topics, t)
+	}
+	mq.mu.Unlock()
+
+	for _, topic := range topics {
+		if err := mq.attachPattern(ps, topic); err != nil {
+			mq.UnsubscribePattern(ps.ID)
+			return nil, err
+		}
+	}
+
+	log.Printf("[LpacaMQ] Pattern subscription %s on %s matched %d topics", ps.ID, pattern, len(ps.Topics()))
+	return ps, nil
+}
+
+// UnsubscribePattern stops a pattern subscription on every matched topic
+func (mq *LpacaMQ) UnsubscribePattern(id string) error {
+	mq.mu.Lock()
+	ps, exists := mq.patterns[id]
+	delete(mq.patterns, id)
+	mq.mu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("pattern subscription %s not found", id)
+	}
+
+	ps.mu.Lock()
+	consumers := ps.consumers
+	ps.consumers = make(map[string]*Consumer)
+	ps.mu.Unlock()
+
+	for topicName, c := range consumers {
+		mq.Unsubscribe(c.ID)
+		if !ps.ownsGroup {
+			continue
+		}
+		if topic, err := mq.GetTopic(topicName); err == nil {
+			topic.DeleteSubscription(ps.group)
+		}
+	}
+	return nil
+}
+
+// attachPattern starts consuming topic for ps if the name matches
+func (mq *LpacaMQ) attachPattern(ps *PatternSubscription, topic *Topic) error {
+	if !MatchTopic(ps.Pattern, topic.Name) || strings.HasPrefix(topic.Name, replyTopicPrefix) {
+		return nil
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if c, ok := ps.consumers[topic.Name]; ok {
+		if c.topic == topic {
+			return nil
+		}
+		mq.Unsubscribe(c.ID) // left over from a deleted topic of the same name
+	}
+	c, err := mq.SubscribeWithHandler(topic.Name, ps.handler, ps.opts...)
+	if err != nil {
+		return err
+	}
+	ps.consumers[topic.Name] = c
+	return nil
+}
+
+// topicCreated attaches matching pattern subscriptions to a new topic
+// must be called without holding mq.mu
+func (mq *LpacaMQ) topicCreated(topic *Topic) {
+	mq.mu.RLock()
+	patterns := make([]*PatternSubscription, 0, len(mq.patterns))
+	for _, ps := range mq.patterns {
+		patterns = append(patterns, ps)
+	}
+	mq.mu.RUnlock()
+
+	for _, ps := range patterns {
+		if err := mq.attachPattern(ps, topic); err != nil {
+			log.Printf("[LpacaMQ] Pattern %s failed to attach to %s: %v", ps.Pattern, topic.Name, err)
+		}
+	}
+}
+
+// topicDeleted stops the pattern consumers of a deleted topic, so a topic
+// re-created under the same name is attached afresh
+// must be called without holding mq.mu
+func (mq *LpacaMQ) topicDeleted(topic *Topic) {
+	mq.mu.RLock()
+	patterns := make([]*PatternSubscription, 0, len(mq.patterns))
+	for _, ps := range mq.patterns {
+		patterns = append(patterns, ps)
+	}
+	mq.mu.RUnlock()
+
+	for _, ps := range patterns {
+		ps.mu.Lock()
+		c, ok := ps.consumers[topic.Name]
+		if ok = ok && c.topic == topic; ok {
+			delete(ps.consumers, topic.Name)
+		}
+		ps.mu.Unlock()
+		if ok {
+			mq.Unsubscribe(c.ID)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package lpacamq
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"orders.*.created", "orders.eu.created", true},
+		{"orders.*.created", "orders.eu.west.created", false},
+		{"orders.#", "orders", true},
+		{"orders.#", "orders.eu.created", true},
+		{"orders.#.created", "orders.created", true},
+		{"orders.>", "orders.eu.created", true},
+		{"orders.>", "orders", false},
+		{"orders.*", "payments.eu", false},
+		{"orders.eu", "orders.eu", true},
+	}
+
+	for _, c := range cases {
+		if got := MatchTopic(c.pattern, c.topic); got != c.want {
+			t.Errorf("MatchTopic(%q, %q) = %v, want %v", c.pattern, c.topic, got, c.want)
+		}
+	}
+
+	if IsTopicPattern("orders.eu") || !IsTopicPattern("orders.*") {
+		t.Error("IsTopicPattern misclassified a name")
+	}
+}
+
+func TestSubscribePattern(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	mq.CreateTopic("orders.eu.created")
+	mq.CreateTopic("payments.eu.created")
+
+	var mu sync.Mutex
+	var got []string
+	ps, err := mq.SubscribePattern("orders.*.created", func(msg *Message) error {
+		mu.Lock()
+		got = append(got, msg.Topic)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SubscribePattern failed: %v", err)
+	}
+
+	// Topic created after subscribing is picked up too
+	mq.Publish("orders.us.created", []byte("new"))
+	mq.Publish("orders.eu.created", []byte("existing"))
+	mq.Publish("payments.eu.created", []byte("ignored"))
+
+	time.Sleep(100 * time.Millisecond)
+
+	if topics := ps.Topics(); len(topics) != 2 {
+		t.Errorf("Expected 2 matched topics, got %v", topics)
+	}
+	mu.Lock()
+	if len(got) != 2 {
+		t.Errorf("Expected 2 messages, got %v", got)
+	}
+	mu.Unlock()
+
+	if err := mq.UnsubscribePattern(ps.ID); err != nil {
+		t.Errorf("UnsubscribePattern failed: %v", err)
+	}
+	if mq.GetConsumerCount() != 0 {
+		t.Errorf("Expected no consumers left, got %d", mq.GetConsumerCount())
+	}
+}
+
+func TestPatternGroupNotRestored(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+	mq.CreateTopic("orders.eu")
+
+	if _, err := mq.SubscribePattern("orders.*", func(*Message) error { return nil }); err != nil {
+		t.Fatalf("SubscribePattern failed: %v", err)
+	}
+	mq.Publish("orders.eu", []byte("order-1"))
+	wal.Close() // crash before the pattern subscription is removed
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	if err := mq2.AttachWAL(wal2); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+	topic, _ := mq2.GetTopic("orders.eu")
+	if subs := topic.Subscriptions(); len(subs) != 0 {
+		t.Errorf("Generated pattern subscriptions should not survive a restart, got %v", subs)
+	}
+}
+
+func TestPatternReattachesRecreatedTopic(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	mq.CreateTopic("orders.eu")
+
+	got := make(chan string, 1)
+	ps, err := mq.SubscribePattern("orders.*", func(msg *Message) error {
+		got <- string(msg.Payload)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SubscribePattern failed: %v", err)
+	}
+
+	mq.DeleteTopic("orders.eu")
+	if topics := ps.Topics(); len(topics) != 0 || mq.GetConsumerCount() != 0 {
+		t.Errorf("Expected the deleted topic detached, got %v", topics)
+	}
+
+	mq.CreateTopic("orders.eu")
+	mq.Publish("orders.eu", []byte("after"))
+	select {
+	case payload := <-got:
+		if payload != "after" {
+			t.Errorf("Expected the new message, got %s", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the re-created topic delivered")
+	}
+}
@@ -9,6 +9,31 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
+)
+
+// WAL operations
+const (
+	OpPublish = "PUBLISH"
+	OpAck     = "ACK"    // MessageIDs finished processing
+	OpRemove  = "REMOVE" // MessageIDs dropped by purge or removal
+	OpSubscribe   = "SUBSCRIBE"   // named subscription created
+	OpUnsubscribe = "UNSUBSCRIBE" // named subscription deleted
+	OpDeclareExchange = "DECLARE_EXCHANGE"
+	OpDeleteExchange  = "DELETE_EXCHANGE"
+	OpBind            = "BIND"
+	OpUnbind          = "UNBIND"
+	OpTopicConfig     = "TOPIC_CONFIG" // topic created or reconfigured
+	OpDeleteTopic     = "DELETE_TOPIC" // topic and its backlog dropped
+	OpDeclareNamespace = "DECLARE_NAMESPACE" // namespace created or reconfigured
+	OpDeleteNamespace  = "DELETE_NAMESPACE"
+	OpRegisterSchema      = "REGISTER_SCHEMA"
+	OpSchemaCompatibility = "SCHEMA_COMPATIBILITY"
+	OpPublishBatch        = "PUBLISH_BATCH" // Messages of an atomic batch, each with its Topic
+	OpBeginTx             = "BEGIN_TX"      // PUBLISH entries with the TxID follow
+	OpCommitTx            = "COMMIT_TX"
+	OpAbortTx             = "ABORT_TX"
+	OpDedupWindow         = "DEDUP_WINDOW" // DedupTTL and DedupMaxKeys of a topic
 )
 
 type WALEntry struct {
@@ -17,6 +42,21 @@ type WALEntry struct {
 	Operation string // pub, ack; etc
 	Topic string
 	Message *Message
+	MessageIDs []string `json:",omitempty"`
+	Subscription string `json:",omitempty"` // empty for the topic's default queue
+	Filter string `json:",omitempty"` // subscription filter expression
+	Exchange string `json:",omitempty"`
+	ExchangeType ExchangeType `json:",omitempty"`
+	Binding *Binding `json:",omitempty"`
+	TopicConfig *TopicConfig `json:",omitempty"`
+	Namespace string `json:",omitempty"` // empty for the root namespace
+	NamespaceConfig *NamespaceConfig `json:",omitempty"`
+	Schema json.RawMessage `json:",omitempty"`
+	SchemaCompatibility SchemaCompatibility `json:",omitempty"`
+	Messages []*Message `json:",omitempty"`
+	TxID string `json:",omitempty"` // transaction of a publish or marker
+	DedupTTL time.Duration `json:",omitempty"`
+	DedupMaxKeys int `json:",omitempty"`
 }
 
 type WAL struct {
@@ -112,6 +152,9 @@ func (w *WAL) Recover() ([]*WALEntry, error) {
 			continue // Skip corrupted entry
 		}
 		
+		if entry.Sequence > w.seq {
+			w.seq = entry.Sequence // continue numbering after a restart
+		}
 		entries = append(entries, &entry)
 	}
 	
@@ -137,4 +137,109 @@ func TestWALCorruption(t *testing.T) {
 	}
 
 	wal2.Close()
-}
\ No newline at end of file
+}
+func TestWALRestoresPendingMessages(t *testing.T) {
+	dir := "./test_wal_restore"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+
+	mq.Publish("orders", []byte("acked"))
+	removed, _ := mq.Publish("orders", []byte("removed"))
+	mq.Publish("orders", []byte("pending"))
+
+	queue, _ := mq.Subscribe("orders")
+	msg, _ := queue.PopNonBlocking()
+	queue.Release(msg) // processed
+
+	topic, _ := mq.GetTopic("orders")
+	topic.RemoveByID(removed.ID)
+	wal.Close()
+
+	// Simulate a restart
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	if err := mq2.AttachWAL(wal2); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+
+	topic2, err := mq2.GetTopic("orders")
+	if err != nil {
+		t.Fatalf("Topic not restored: %v", err)
+	}
+	if topic2.Len() != 1 {
+		t.Fatalf("Expected 1 restored message, got %d", topic2.Len())
+	}
+	if head, _ := topic2.Peek(); string(head.Payload) != "pending" {
+		t.Errorf("Expected 'pending', got '%s'", string(head.Payload))
+	}
+}
+
+func TestWALPreservesHeaders(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+
+	mq.Publish("orders", []byte("o-1"), WithHeaders(map[string]string{"content-type": "application/json", "trace": "abc"}))
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	mq2.AttachWAL(wal2)
+
+	topic, err := mq2.GetTopic("orders")
+	if err != nil {
+		t.Fatalf("Topic not restored: %v", err)
+	}
+	head, ok := topic.Peek()
+	if !ok || head.Headers["content-type"] != "application/json" || head.Headers["trace"] != "abc" {
+		t.Errorf("Expected headers restored, got %v", head)
+	}
+}
+
+func TestWALDeleteTopic(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+
+	mq.CreateTopic("audit")
+	mq.Publish("audit", []byte("unacked"))
+	mq.Subscribe("orders", WithGroup("billing"))
+	mq.Publish("orders", []byte("old"))
+
+	mq.DeleteTopic("audit")
+	mq.DeleteTopic("orders")
+	mq.Publish("orders", []byte("new"))
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	if err := mq2.AttachWAL(wal2); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+
+	if _, err := mq2.GetTopic("audit"); err == nil {
+		t.Error("Expected the deleted topic to stay deleted")
+	}
+	orders, err := mq2.GetTopic("orders")
+	if err != nil {
+		t.Fatalf("Expected the re-created topic restored: %v", err)
+	}
+	if _, ok := orders.Subscription("billing"); ok {
+		t.Error("Expected the group deleted with the topic")
+	}
+	if orders.Len() != 1 {
+		t.Fatalf("Expected only the message published after the deletion, got %d", orders.Len())
+	}
+	if head, _ := orders.Peek(); string(head.Payload) != "new" {
+		t.Errorf("Expected 'new', got '%s'", head.Payload)
+	}
+}
@@ -4,21 +4,48 @@ package lpacamq
 
 import (
 	"errors"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrQueueFull is returned by Push when the queue is at its max depth and
+// its overflow policy is reject
+var ErrQueueFull = errors.New("queue is full")
+
 // q is a simple thread safe fifo q for msgs
+// messages sharing a GroupID are handed out one at a time: the group stays
+// locked until the in-flight message is released, which Pop does at once
+// on a partitioned q the same applies to each partition
 type Queue struct {
 	messages 	[]*Message
+	locks		map[string]string // group or partition lock -> in-flight message id
+	partitioned	bool
+	onRelease	func(*Message) // called once a delivered message is finished
+	leases		map[string]*lease // receipt handle -> hidden message
+	inflight	map[*Message]struct{} // handed out, not yet released or requeued
+	maxDepth	int // 0 is unbounded
+	overflow	OverflowPolicy
+	retention	time.Duration // queued messages older than this are dropped
+	hasTTL		bool // some queued message carries an expiry
+	onDrop		func([]*Message) // called under q.mu for messages discarded by overflow or expiry
+	seq			uint64 // creation order, queues locked together are locked in this order
 	mu 			sync.Mutex
 	cond		*sync.Cond
 	closed		bool
 }
 
+var queueSeq uint64
+
 // NewQueue creates a new empty queue
 func NewQueue() *Queue {
 	q := &Queue{
+		seq:      atomic.AddUint64(&queueSeq, 1),
 		messages: make([]*Message, 0),
+		locks:    make(map[string]string),
+		leases:   make(map[string]*lease),
+		inflight: make(map[*Message]struct{}),
 	}
 	q.cond = sync.NewCond(&q.mu)
 	return q
@@ -28,52 +55,305 @@ func NewQueue() *Queue {
 func (q *Queue) Push(msg *Message) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
+	return q.push(msg)
+}
 
+// push is Push, caller holds q.mu
+func (q *Queue) push(msg *Message) error {
 	if q.closed {
 		return errors.New("queue is closed")
 	}
 
+	// pushing a delivered message back requeues it and is never refused
+	if _, requeue := q.inflight[msg]; !requeue && q.maxDepth > 0 && len(q.messages) >= q.maxDepth {
+		switch q.overflow {
+		case OverflowDropNewest:
+			if q.onDrop != nil {
+				q.onDrop([]*Message{msg})
+			}
+			return nil
+		case OverflowDropOldest:
+			q.drop(len(q.messages) - q.maxDepth + 1)
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	delete(q.inflight, msg)
+	if !msg.ExpiresAt.IsZero() {
+		q.hasTTL = true
+	}
 	q.messages = append(q.messages, msg)
 	q.cond.Signal() // signal waiting goroutines
 	return nil
 }
 
-// pop removes and returns the next message from the q, blocking if empty
-func (q *Queue) Pop() (*Message, error) {
+// requeueFront puts msg at the head and unlocks its group, caller holds q.mu
+func (q *Queue) requeueFront(msg *Message) {
+	q.unlock(msg)
+	delete(q.inflight, msg)
+	q.messages = append([]*Message{msg}, q.messages...)
+	q.cond.Broadcast()
+}
+
+// redeliver puts a delivered message back for another attempt: ordered
+// messages go to the head to keep their place, others to the tail
+func (q *Queue) redeliver(msg *Message) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	for len(q.messages) == 0 && !q.closed {
-		q.cond.Wait() // wait for a message to be pushed
+	if q.closed {
+		return errors.New("queue is closed")
 	}
 
-	if len(q.messages) == 0 && q.closed {
+	if len(q.lockKeys(msg)) > 0 {
+		q.requeueFront(msg)
+		return nil
+	}
+	delete(q.inflight, msg)
+	q.messages = append(q.messages, msg)
+	q.cond.Signal()
+	return nil
+}
+
+// unread returns delivered but unprocessed messages to the head of the q in
+// their original order, as if they had never been taken
+func (q *Queue) unread(msgs ...*Message) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, msg := range msgs {
+		q.unlock(msg)
+		delete(q.inflight, msg)
+	}
+	q.messages = append(append([]*Message(nil), msgs...), q.messages...)
+	q.cond.Broadcast()
+}
+
+// pop removes and returns the next message from the q, blocking if empty
+// a popped message is finished at once, consumers keep theirs in flight
+// until they release it
+func (q *Queue) Pop() (*Message, error) {
+	q.mu.Lock()
+	i := q.next()
+	for i < 0 && !q.closed {
+		q.cond.Wait() // wait for a message to be pushed or a group released
+		i = q.next()
+	}
+
+	if i < 0 && q.closed {
+		q.mu.Unlock()
 		return nil, errors.New("queue is closed")
 	}
 
-	msg := q.messages[0]
-	q.messages = q.messages[1:]
+	msg := q.take(i)
+	q.mu.Unlock()
+	q.Release(msg)
 	return msg, nil
 }
 
-// popnonBlocking tries to get a msg without blocking
+// popnonBlocking tries to get a msg without blocking, like Pop it finishes
+// the message at once
 func (q *Queue) PopNonBlocking() (*Message, bool) {
+	msg, ok := q.checkout(nil)
+	if ok {
+		q.Release(msg)
+	}
+	return msg, ok
+}
+
+// PopFrom is PopNonBlocking restricted to the given partitions
+func (q *Queue) PopFrom(partitions ...int) (*Message, bool) {
+	msg, ok := q.checkout(partitionSet(partitions))
+	if ok {
+		q.Release(msg)
+	}
+	return msg, ok
+}
+
+// checkout takes the next message from the allowed partitions, nil allows
+// all. It stays in flight, holding its group and partition, until Release
+func (q *Queue) checkout(allowed map[int]bool) (*Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.nextFrom(allowed)
+	if i < 0 {
+		return nil, false
+	}
+
+	return q.take(i), true
+}
+
+// partitionSet turns a partition list into the allowed set of checkout
+func partitionSet(partitions []int) map[int]bool {
+	allowed := make(map[int]bool, len(partitions))
+	for _, p := range partitions {
+		allowed[p] = true
+	}
+	return allowed
+}
+
+// Release marks a delivered message as finished: it no longer counts as in
+// flight and the next message of its group can be delivered
+func (q *Queue) Release(msg *Message) {
+	q.mu.Lock()
+	if q.unlock(msg) {
+		q.cond.Broadcast()
+	}
+	_, delivered := q.inflight[msg]
+	delete(q.inflight, msg)
+	onRelease := q.onRelease
+	q.mu.Unlock()
+
+	if delivered && onRelease != nil {
+		onRelease(msg)
+	}
+}
+
+// InFlight returns how many delivered messages have not been released yet
+func (q *Queue) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.inflight)
+}
+
+// RemoveIf drops every queued message matching match and returns them
+// in-flight messages are not affected
+func (q *Queue) RemoveIf(match func(*Message) bool) []*Message {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	if len(q.messages) == 0 {
+	var removed []*Message
+	kept := q.messages[:0]
+	for _, msg := range q.messages {
+		if match(msg) {
+			removed = append(removed, msg)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	for i := len(kept); i < len(q.messages); i++ {
+		q.messages[i] = nil // let removed messages be collected
+	}
+	q.messages = kept
+	return removed
+}
+
+// next finds the first deliverable message, skipping locked groups and
+// partitions, caller holds q.mu, returns -1 if nothing can be delivered
+func (q *Queue) next() int {
+	return q.nextFrom(nil)
+}
+
+// nextFrom is next limited to the allowed partitions, nil allows all
+func (q *Queue) nextFrom(allowed map[int]bool) int {
+	q.dropExpired()
+	if len(q.locks) == 0 && allowed == nil && len(q.messages) > 0 {
+		return 0
+	}
+	for i, msg := range q.messages {
+		if allowed != nil && !allowed[msg.Partition] {
+			continue
+		}
+		free := true
+		for _, key := range q.lockKeys(msg) {
+			if _, locked := q.locks[key]; locked {
+				free = false
+				break
+			}
+		}
+		if free {
+			return i
+		}
+	}
+	return -1
+}
+
+// take removes message i and locks its group and partition, caller holds q.mu
+func (q *Queue) take(i int) *Message {
+	msg := q.messages[i]
+	if i == 0 {
+		q.messages = q.messages[1:]
+	} else {
+		q.messages = append(q.messages[:i], q.messages[i+1:]...)
+	}
+	for _, key := range q.lockKeys(msg) {
+		q.locks[key] = msg.ID
+	}
+	q.inflight[msg] = struct{}{}
+	return msg
+}
+
+// unlock frees the locks held by msg, caller holds q.mu
+func (q *Queue) unlock(msg *Message) bool {
+	freed := false
+	for _, key := range q.lockKeys(msg) {
+		if q.locks[key] == msg.ID {
+			delete(q.locks, key)
+			freed = true
+		}
+	}
+	return freed
+}
+
+// lockKeys returns the ordering locks a message needs, caller holds q.mu
+func (q *Queue) lockKeys(msg *Message) []string {
+	var keys []string
+	if msg.GroupID != "" {
+		keys = append(keys, "g:"+msg.GroupID)
+	}
+	if q.partitioned {
+		keys = append(keys, "p:"+strconv.Itoa(msg.Partition))
+	}
+	return keys
+}
+
+// Peek returns the next message to be delivered without removing it,
+// messages of locked groups and partitions are skipped as Pop skips them
+func (q *Queue) Peek() (*Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.next()
+	if i < 0 {
 		return nil, false
 	}
+	return q.messages[i], true
+}
+
+// Browse returns up to limit queued messages starting at offset, in delivery
+// order, without removing them, a limit <= 0 means no limit
+func (q *Queue) Browse(offset, limit int) []*Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 
-	msg := q.messages[0]
-	q.messages = q.messages[1:]
-	return msg, true
+	q.dropExpired()
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(q.messages) {
+		return []*Message{}
+	}
+	end := len(q.messages)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+
+	out := make([]*Message, end-offset)
+	copy(out, q.messages[offset:end])
+	return out
 }
 
 // len returns the number of messages in the q
 func (q *Queue) Len() int {
 	q.mu.Lock()
 	defer q.mu.Unlock()
+	q.dropExpired()
 	return len(q.messages)
 }
 
@@ -81,6 +361,9 @@ func (q *Queue) Len() int {
 func (q *Queue) Close() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
+	for _, l := range q.leases {
+		l.timer.Stop()
+	}
 	q.closed = true
 	q.cond.Broadcast() // wake all waiting goroutines
 }
\ No newline at end of file
@@ -117,4 +117,76 @@ func TestQueueClose(t *testing.T){
 	if err == nil {
 		t.Errorf("Expected error when pushing to closed queue")
 	}
-}
\ No newline at end of file
+}
+func TestQueueMessageGroups(t *testing.T) {
+	q := NewQueue()
+
+	a1 := NewMessage("topic", []byte("a1"))
+	a1.GroupID = "a"
+	a2 := NewMessage("topic", []byte("a2"))
+	a2.GroupID = "a"
+	b1 := NewMessage("topic", []byte("b1"))
+	b1.GroupID = "b"
+	q.Push(a1)
+	q.Push(a2)
+	q.Push(b1)
+
+	first, _ := q.checkout(nil)
+	second, _ := q.checkout(nil)
+	if first.ID != a1.ID || second.ID != b1.ID {
+		t.Fatalf("Expected a1 then b1, got %s then %s", first.Payload, second.Payload)
+	}
+
+	// Group a is in flight until a1 is released
+	if _, ok := q.checkout(nil); ok {
+		t.Error("Group a should be locked while a1 is in flight")
+	}
+
+	q.Release(a1)
+	third, ok := q.checkout(nil)
+	if !ok || third.ID != a2.ID {
+		t.Error("Expected a2 after releasing a1")
+	}
+}
+
+func TestQueuePeekSkipsLockedGroup(t *testing.T) {
+	q := NewQueue()
+
+	a1 := NewMessage("topic", []byte("a1"))
+	a1.GroupID = "a"
+	a2 := NewMessage("topic", []byte("a2"))
+	a2.GroupID = "a"
+	b1 := NewMessage("topic", []byte("b1"))
+	b1.GroupID = "b"
+	q.Push(a1)
+	q.Push(a2)
+	q.Push(b1)
+
+	q.Receive(time.Minute) // a1 holds group a
+
+	next, ok := q.Peek()
+	if !ok || next.ID != b1.ID {
+		t.Errorf("Peek should skip locked group a and return b1, got %v", next)
+	}
+}
+
+func TestQueuePopFinishesMessage(t *testing.T) {
+	q := NewQueue()
+	var acked []string
+	q.onRelease = func(msg *Message) { acked = append(acked, msg.ID) }
+
+	a1 := NewMessage("topic", []byte("a1"))
+	a1.GroupID = "a"
+	a2 := NewMessage("topic", []byte("a2"))
+	a2.GroupID = "a"
+	q.Push(a1)
+	q.Push(a2)
+
+	q.Pop()
+	if q.InFlight() != 0 || len(acked) != 1 || acked[0] != a1.ID {
+		t.Fatalf("Pop should finish a1, in flight %d acked %v", q.InFlight(), acked)
+	}
+	if next, ok := q.PopNonBlocking(); !ok || next.ID != a2.ID {
+		t.Error("Group a should not stay locked after Pop")
+	}
+}
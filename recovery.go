@@ -0,0 +1,255 @@
+package lpacamq
+
+import (
+	"log"
+	"sync"
+)
+
+// pendingDelivery is a recovered message and the subscriptions it was
+// fanned out to when it was published ("" is the default queue)
+type pendingDelivery struct {
+	entry *WALEntry
+	subs  []string
+}
+
+// replay rebuilds topics, subscriptions, exchanges, dedup windows and
+// undelivered messages from WAL entries, returning how many messages were restored
+// It runs before the WAL is attached, so nothing it does is journaled again
+// A message that no longer fits its queue is logged and dropped
+func (mq *LpacaMQ) replay(entries []*WALEntry) int {
+	restored := 0
+	if mq.parent == nil {
+		entries, restored = mq.replayNamespaces(entries)
+	}
+
+	live := make(map[string]map[string]*Filter) // topic -> live named subscriptions
+	used := make(map[string]bool)               // topics whose default queue was subscribed to
+	done := make(map[string]bool)               // subscription + message id
+	var pending []pendingDelivery
+	txs := make(map[string][]*WALEntry) // open transaction -> its publishes
+
+	doneKey := func(sub, id string) string {
+		return sub + "\x00" + id
+	}
+
+	// publish mirrors Topic.fanout as of the entry's place in the WAL
+	publish := func(entry *WALEntry) {
+		var subs []string
+		if used[entry.Topic] || len(live[entry.Topic]) == 0 {
+			subs = append(subs, "")
+		}
+		load := sync.OnceValues(func() ([]byte, error) { return plainPayload(entry.Message, mq.BlobStore()) })
+		for name, filter := range live[entry.Topic] {
+			if filter.match(entry.Message, load) {
+				subs = append(subs, name)
+			}
+		}
+		pending = append(pending, pendingDelivery{entry: entry, subs: subs})
+	}
+
+	for _, entry := range entries {
+		switch entry.Operation {
+		case OpPublish:
+			if entry.Message == nil {
+				continue
+			}
+			if entry.TxID != "" {
+				txs[entry.TxID] = append(txs[entry.TxID], entry)
+				continue
+			}
+			publish(entry)
+		case OpBeginTx:
+			txs[entry.TxID] = nil
+		case OpCommitTx:
+			for _, e := range txs[entry.TxID] {
+				publish(e)
+			}
+			delete(txs, entry.TxID)
+		case OpAbortTx:
+			delete(txs, entry.TxID)
+		case OpPublishBatch:
+			for _, msg := range entry.Messages {
+				publish(&WALEntry{Operation: OpPublish, Topic: msg.Topic, Message: msg})
+			}
+		case OpAck, OpRemove:
+			for _, id := range entry.MessageIDs {
+				done[doneKey(entry.Subscription, id)] = true
+			}
+		case OpSubscribe:
+			if entry.Subscription == "" {
+				used[entry.Topic] = true
+				continue
+			}
+			if live[entry.Topic] == nil {
+				live[entry.Topic] = make(map[string]*Filter)
+			}
+			var filter *Filter
+			if entry.Filter != "" {
+				f, err := ParseFilter(entry.Filter)
+				if err != nil {
+					log.Printf("[LpacaMQ] Dropping bad filter on %s/%s: %v", entry.Topic, entry.Subscription, err)
+				}
+				filter = f
+			}
+			live[entry.Topic][entry.Subscription] = filter
+		case OpUnsubscribe:
+			delete(live[entry.Topic], entry.Subscription)
+		case OpTopicConfig:
+			if entry.TopicConfig == nil {
+				continue
+			}
+			topic := mq.restoreTopic(entry.Topic)
+			if err := topic.SetConfig(*entry.TopicConfig); err != nil {
+				log.Printf("[LpacaMQ] Dropping bad config of topic %s: %v", entry.Topic, err)
+			}
+		case OpRegisterSchema, OpSchemaCompatibility:
+			topic := mq.restoreTopic(entry.Topic)
+			if err := topic.replaySchema(entry); err != nil {
+				log.Printf("[LpacaMQ] Dropping bad schema entry of topic %s: %v", entry.Topic, err)
+			}
+		case OpDedupWindow:
+			mq.restoreTopic(entry.Topic).SetDedupWindow(entry.DedupTTL, entry.DedupMaxKeys)
+		case OpDeleteTopic:
+			// Everything recorded for the topic so far went with it
+			delete(live, entry.Topic)
+			delete(used, entry.Topic)
+			kept := pending[:0]
+			for _, p := range pending {
+				if p.entry.Topic != entry.Topic {
+					kept = append(kept, p)
+				}
+			}
+			pending = kept
+			mq.dropRestoredTopic(entry.Topic)
+		case OpDeclareExchange, OpDeleteExchange, OpBind, OpUnbind:
+			mq.replayExchange(entry)
+		}
+	}
+
+	for topicName := range used {
+		topic := mq.restoreTopic(topicName)
+		topic.mu.Lock()
+		topic.queueUsed = true
+		topic.mu.Unlock()
+	}
+	for topicName, subs := range live {
+		topic := mq.restoreTopic(topicName)
+		for name, filter := range subs {
+			sub, ok := topic.Subscription(name)
+			if !ok {
+				topic.mu.Lock()
+				sub = topic.addSubscription(name)
+				topic.mu.Unlock()
+			}
+			sub.filter.Store(filter)
+		}
+	}
+
+	for id, list := range txs {
+		log.Printf("[LpacaMQ] Rolled back transaction %s left open with %d messages", id, len(list))
+	}
+
+	for _, p := range pending {
+		msg := p.entry.Message
+		topic := mq.restoreTopic(p.entry.Topic)
+
+		// Keys go back once the final window is known, acked messages included
+		if msg.IdempotencyKey != "" {
+			topic.dedup.restore(msg.IdempotencyKey, msg.ID, msg.Timestamp)
+		}
+		for _, name := range p.subs {
+			if done[doneKey(name, msg.ID)] {
+				continue
+			}
+
+			queue := topic.queue
+			if name != "" {
+				sub, ok := topic.Subscription(name)
+				if !ok {
+					continue // deleted since
+				}
+				queue = sub.queue
+			}
+			topic.retainBlob(msg, 1)
+			if err := queue.Push(msg.clone()); err != nil {
+				// e.g. the topic's depth was lowered since, the rest still recovers
+				log.Printf("[LpacaMQ] Dropping message %s of %s on restore: %v", msg.ID, p.entry.Topic, err)
+				topic.retainBlob(msg, -1)
+				continue
+			}
+			restored++
+		}
+	}
+
+	return restored
+}
+
+// journalSubscriptions records every durable subscription once the WAL is
+// attached. Those made before, by bridges from NewWithConfig or while
+// replaying, were never journaled, and the next recovery would not fan
+// messages out to them
+func (mq *LpacaMQ) journalSubscriptions() {
+	mq.mu.RLock()
+	topics := make([]*Topic, 0, len(mq.topics))
+	for _, t := range mq.topics {
+		topics = append(topics, t)
+	}
+	namespaces := make([]*LpacaMQ, 0, len(mq.namespaces))
+	for _, ns := range mq.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	mq.mu.RUnlock()
+
+	for _, ns := range namespaces {
+		ns.journalSubscriptions()
+	}
+	for _, topic := range topics {
+		topic.mu.RLock()
+		used := topic.queueUsed
+		subs := make([]*Subscription, 0, len(topic.subs))
+		for _, sub := range topic.subs {
+			if !sub.ephemeral {
+				subs = append(subs, sub)
+			}
+		}
+		topic.mu.RUnlock()
+
+		if used {
+			topic.journalSubscription(OpSubscribe, "", nil)
+		}
+		for _, sub := range subs {
+			topic.journalSubscription(OpSubscribe, sub.Name, sub.Filter())
+		}
+	}
+}
+
+// dropRestoredTopic removes a topic restored earlier in the replay, recovery
+// only
+func (mq *LpacaMQ) dropRestoredTopic(name string) {
+	mq.mu.Lock()
+	topic, exists := mq.topics[name]
+	delete(mq.topics, name)
+	mq.mu.Unlock()
+
+	if exists {
+		mq.topicDeleted(topic)
+		topic.Close()
+	}
+}
+
+// restoreTopic is getOrCreateTopic without quota checks, recovery only
+// recreates topics that existed before
+func (mq *LpacaMQ) restoreTopic(name string) *Topic {
+	mq.mu.Lock()
+	topic, exists := mq.topics[name]
+	if !exists {
+		topic, _ = mq.newTopic(name, mq.nsConfig.TopicDefaults) // the defaults were validated when set
+		mq.topics[name] = topic
+	}
+	mq.mu.Unlock()
+
+	if !exists {
+		mq.topicCreated(topic)
+	}
+	return topic
+}
@@ -0,0 +1,170 @@
+package lpacamq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Request/reply headers
+const (
+	ReplyToHeader       = "reply-to"
+	CorrelationIDHeader = "correlation-id"
+)
+
+// DefaultRequestTimeout bounds a Request whose context has no deadline
+const DefaultRequestTimeout = 30 * time.Second
+
+// replyRouter consumes the broker's private reply topic and hands each reply
+// to the Request waiting on its correlation ID
+type replyRouter struct {
+	topic    string
+	consumer *Consumer
+	pending  map[string]chan *Message
+	mu       sync.Mutex
+}
+
+func (r *replyRouter) handle(msg *Message) error {
+	id := msg.Headers[CorrelationIDHeader]
+
+	r.mu.Lock()
+	ch, ok := r.pending[id]
+	delete(r.pending, id)
+	r.mu.Unlock()
+
+	if !ok {
+		log.Printf("[LpacaMQ] Dropping late or unknown reply %s for request %s", msg.ID, id)
+		return nil
+	}
+	ch <- msg // buffered, never blocks
+	return nil
+}
+
+func (r *replyRouter) cancel(id string) {
+	r.mu.Lock()
+	delete(r.pending, id)
+	r.mu.Unlock()
+}
+
+// Request publishes payload to topic and waits for the reply. The request
+// carries reply-to and correlation-id headers pointing at a private reply
+// topic; the responder answers with Reply. A request nobody has picked up by
+// the deadline expires from the queue, a reply arriving after it is dropped
+// Without a deadline on ctx, DefaultRequestTimeout applies
+func (mq *LpacaMQ) Request(ctx context.Context, topic string, payload []byte, opts ...PublishOption) (*Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	router, err := mq.replyRouter()
+	if err != nil {
+		return nil, err
+	}
+
+	o := applyPublishOptions(opts)
+	headers := make(map[string]string, len(o.headers)+2)
+	for k, v := range o.headers {
+		headers[k] = v
+	}
+	id := GenerateID()
+	headers[ReplyToHeader] = router.topic
+	headers[CorrelationIDHeader] = id
+	if _, ok := headers[TraceparentHeader]; !ok {
+		if tc, ok := TraceFromContext(ctx); ok {
+			headers[TraceparentHeader] = tc.String() // e.g. a request made from a handler
+		}
+	}
+
+	ch := make(chan *Message, 1)
+	router.mu.Lock()
+	router.pending[id] = ch
+	router.mu.Unlock()
+
+	opts = append(opts, WithHeaders(headers), WithTTL(time.Until(deadline)))
+	if _, err := mq.Publish(topic, payload, opts...); err != nil {
+		router.cancel(id)
+		return nil, err
+	}
+
+	select {
+	case reply := <-ch:
+		return reply, nil
+	case <-ctx.Done():
+		router.cancel(id)
+		return nil, fmt.Errorf("request %s to %s: %w", id, topic, ctx.Err())
+	}
+}
+
+// Reply answers a message sent with Request, publishing payload to its
+// reply topic with the same correlation ID
+func (mq *LpacaMQ) Reply(req *Message, payload []byte, opts ...PublishOption) error {
+	replyTo, id := req.Headers[ReplyToHeader], req.Headers[CorrelationIDHeader]
+	if replyTo == "" || id == "" {
+		return fmt.Errorf("message %s is not a request", req.ID)
+	}
+
+	o := applyPublishOptions(opts)
+	headers := make(map[string]string, len(o.headers)+1)
+	for k, v := range o.headers {
+		headers[k] = v
+	}
+	headers[CorrelationIDHeader] = id
+	if _, ok := headers[TraceparentHeader]; !ok {
+		if tp, ok := req.Headers[TraceparentHeader]; ok {
+			headers[TraceparentHeader] = tp // the reply continues the request's trace
+		}
+	}
+
+	_, err := mq.Publish(replyTo, payload, append(opts, WithHeaders(headers))...)
+	return err
+}
+
+// replyTopicPrefix names the broker's private reply topics, pattern
+// subscriptions never match them
+const replyTopicPrefix = "_replies."
+
+// replyRouter returns the broker's reply router, creating the reply topic
+// and its consumer on first use. The topic is not persistent and not counted
+// against quotas, pending requests do not survive a restart anyway
+func (mq *LpacaMQ) replyRouter() (*replyRouter, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	if mq.replies != nil {
+		return mq.replies, nil
+	}
+
+	cfg := DefaultTopicConfig()
+	cfg.Persistent = false
+	name := replyTopicPrefix + GenerateID()
+	topic, err := mq.newTopic(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	// The router consumes the default queue. It is marked used directly,
+	// journaling it would bring the private topic back after a restart
+	topic.queueUsed = true
+	mq.topics[name] = topic
+
+	r := &replyRouter{topic: name, pending: make(map[string]chan *Message)}
+	r.consumer = NewConsumer(GenerateID(), name, r.handle, topic.queue)
+	r.consumer.Start()
+	mq.replies = r
+	return r, nil
+}
+
+// stopReplies stops the reply consumer on shutdown
+func (mq *LpacaMQ) stopReplies() {
+	mq.mu.RLock()
+	r := mq.replies
+	mq.mu.RUnlock()
+
+	if r != nil {
+		r.consumer.Stop()
+	}
+}
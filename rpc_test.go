@@ -0,0 +1,90 @@
+package lpacamq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestReply(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	mq.SubscribeWithHandler("rpc.upper", func(msg *Message) error {
+		return mq.Reply(msg, []byte(strings.ToUpper(string(msg.Payload))))
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, word := range []string{"ping", "pong"} {
+		reply, err := mq.Request(ctx, "rpc.upper", []byte(word), WithHeaders(map[string]string{"caller": "test"}))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if string(reply.Payload) != strings.ToUpper(word) {
+			t.Errorf("Expected %s, got %s", strings.ToUpper(word), reply.Payload)
+		}
+		if reply.Headers[CorrelationIDHeader] == "" {
+			t.Error("Expected the reply to carry the correlation ID")
+		}
+	}
+
+	if err := mq.Reply(NewMessage("plain", nil), []byte("x")); err == nil {
+		t.Error("Expected Reply to a non-request to fail")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+	mq.CreateTopic("rpc.slow")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	_, err := mq.Request(ctx, "rpc.slow", []byte("anyone?"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a deadline error, got %v", err)
+	}
+
+	// The unanswered request is forgotten and expires from the queue
+	if n := len(mq.replies.pending); n != 0 {
+		t.Errorf("Expected no pending requests, got %d", n)
+	}
+	time.Sleep(10 * time.Millisecond)
+	topic, _ := mq.GetTopic("rpc.slow")
+	if topic.Len() != 0 {
+		t.Errorf("Expected the request to expire, got depth %d", topic.Len())
+	}
+}
+
+func TestRequestWithWildcardSubscriber(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	mq.SubscribeWithHandler("rpc.echo", func(msg *Message) error {
+		return mq.Reply(msg, msg.Payload)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := mq.Request(ctx, "rpc.echo", []byte("first")); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	// A catch-all pattern must neither see replies nor starve the router
+	ps, err := mq.SubscribePattern("#", func(*Message) error { return nil })
+	if err != nil {
+		t.Fatalf("SubscribePattern failed: %v", err)
+	}
+	for _, name := range ps.Topics() {
+		if strings.HasPrefix(name, replyTopicPrefix) {
+			t.Errorf("Expected the reply topic left out of patterns, matched %s", name)
+		}
+	}
+	if reply, err := mq.Request(ctx, "rpc.echo", []byte("second")); err != nil || string(reply.Payload) != "second" {
+		t.Errorf("Expected the request answered, got %v", err)
+	}
+}
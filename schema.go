@@ -0,0 +1,562 @@
+package lpacamq
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SchemaVersionHeader carries the version of the schema a payload was
+// validated against
+const SchemaVersionHeader = "schema-version"
+
+// SchemaCompatibility decides which new schema versions are accepted
+type SchemaCompatibility string
+
+const (
+	CompatibilityNone     SchemaCompatibility = "none"
+	CompatibilityBackward SchemaCompatibility = "backward" // the new schema accepts data valid under the previous one
+	CompatibilityForward  SchemaCompatibility = "forward"  // the previous schema accepts data valid under the new one
+	CompatibilityFull     SchemaCompatibility = "full"     // both
+)
+
+func (c SchemaCompatibility) valid() bool {
+	switch c {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityForward, CompatibilityFull:
+		return true
+	}
+	return false
+}
+
+// Schema is a compiled JSON Schema. The supported keywords are type, enum,
+// properties, required, additionalProperties (boolean), items, minimum,
+// maximum, minLength, maxLength, minItems, maxItems and pattern, anything
+// else is ignored
+type Schema struct {
+	Types                []string
+	Enum                 []interface{}
+	Properties           map[string]*Schema
+	Required             []string
+	AdditionalProperties *bool
+	Items                *Schema
+	Minimum, Maximum     *float64
+	MinLength, MaxLength *float64
+	MinItems, MaxItems   *float64
+	Pattern              *regexp.Regexp
+}
+
+// ParseSchema compiles a JSON Schema document
+func ParseSchema(doc []byte) (*Schema, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	return compileSchema(raw, "")
+}
+
+func compileSchema(raw map[string]interface{}, path string) (*Schema, error) {
+	s := &Schema{}
+	fail := func(format string, args ...interface{}) (*Schema, error) {
+		return nil, fmt.Errorf("schema%s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	switch t := raw["type"].(type) {
+	case nil:
+	case string:
+		s.Types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return fail("type must be a string or a list of strings")
+			}
+			s.Types = append(s.Types, name)
+		}
+	default:
+		return fail("type must be a string or a list of strings")
+	}
+	for _, t := range s.Types {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fail("unknown type %q", t)
+		}
+	}
+
+	if enum, ok := raw["enum"]; ok {
+		values, ok := enum.([]interface{})
+		if !ok {
+			return fail("enum must be a list")
+		}
+		s.Enum = values
+	}
+
+	if props, ok := raw["properties"]; ok {
+		m, ok := props.(map[string]interface{})
+		if !ok {
+			return fail("properties must be an object")
+		}
+		s.Properties = make(map[string]*Schema, len(m))
+		for name, sub := range m {
+			subRaw, ok := sub.(map[string]interface{})
+			if !ok {
+				return fail("property %s must be a schema", name)
+			}
+			compiled, err := compileSchema(subRaw, path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			s.Properties[name] = compiled
+		}
+	}
+
+	if req, ok := raw["required"]; ok {
+		list, ok := req.([]interface{})
+		if !ok {
+			return fail("required must be a list of strings")
+		}
+		for _, v := range list {
+			name, ok := v.(string)
+			if !ok {
+				return fail("required must be a list of strings")
+			}
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	if ap, ok := raw["additionalProperties"]; ok {
+		b, ok := ap.(bool)
+		if !ok {
+			return fail("additionalProperties must be a boolean")
+		}
+		s.AdditionalProperties = &b
+	}
+
+	if items, ok := raw["items"]; ok {
+		m, ok := items.(map[string]interface{})
+		if !ok {
+			return fail("items must be a schema")
+		}
+		compiled, err := compileSchema(m, path+"[]")
+		if err != nil {
+			return nil, err
+		}
+		s.Items = compiled
+	}
+
+	bounds := map[string]**float64{
+		"minimum": &s.Minimum, "maximum": &s.Maximum,
+		"minLength": &s.MinLength, "maxLength": &s.MaxLength,
+		"minItems": &s.MinItems, "maxItems": &s.MaxItems,
+	}
+	for key, dst := range bounds {
+		v, ok := raw[key]
+		if !ok {
+			continue
+		}
+		f, ok := v.(float64)
+		if !ok {
+			return fail("%s must be a number", key)
+		}
+		*dst = &f
+	}
+
+	if p, ok := raw["pattern"]; ok {
+		expr, ok := p.(string)
+		if !ok {
+			return fail("pattern must be a string")
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fail("bad pattern: %v", err)
+		}
+		s.Pattern = re
+	}
+
+	return s, nil
+}
+
+// Validate checks a JSON payload against the schema and returns every problem found
+func (s *Schema) Validate(payload []byte) []string {
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return []string{"payload is not valid JSON: " + err.Error()}
+	}
+	var problems []string
+	s.validate(v, "$", &problems)
+	return problems
+}
+
+func (s *Schema) validate(v interface{}, path string, problems *[]string) {
+	report := func(format string, args ...interface{}) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.Types) > 0 && !typeAllowed(s.Types, jsonType(v)) {
+		report("expected %s, got %s", strings.Join(s.Types, " or "), jsonType(v))
+		return
+	}
+	if s.Enum != nil && !inEnum(s.Enum, v) {
+		report("value not in enum")
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := x[name]; !ok {
+				report("missing required property %s", name)
+			}
+		}
+		names := make([]string, 0, len(x))
+		for name := range x {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := s.Properties[name]; ok {
+				sub.validate(x[name], path+"."+name, problems)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				report("unexpected property %s", name)
+			}
+		}
+	case []interface{}:
+		checkBounds(float64(len(x)), s.MinItems, s.MaxItems, "items", report)
+		if s.Items != nil {
+			for i, item := range x {
+				s.Items.validate(item, path+"["+strconv.Itoa(i)+"]", problems)
+			}
+		}
+	case string:
+		checkBounds(float64(len([]rune(x))), s.MinLength, s.MaxLength, "length", report)
+		if s.Pattern != nil && !s.Pattern.MatchString(x) {
+			report("does not match pattern %s", s.Pattern)
+		}
+	case float64:
+		checkBounds(x, s.Minimum, s.Maximum, "value", report)
+	}
+}
+
+func checkBounds(n float64, min, max *float64, what string, report func(string, ...interface{})) {
+	if min != nil && n < *min {
+		report("%s %v below minimum %v", what, n, *min)
+	}
+	if max != nil && n > *max {
+		report("%s %v above maximum %v", what, n, *max)
+	}
+}
+
+func jsonType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if x == float64(int64(x)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// typeAllowed reports whether t is one of types, integers count as numbers
+func typeAllowed(types []string, t string) bool {
+	for _, allowed := range types {
+		if allowed == t || (allowed == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, candidate := range enum {
+		if reflect.DeepEqual(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// accepts reports why data valid under writer might be rejected by s, the
+// reader. The check is structural and conservative
+func (s *Schema) accepts(writer *Schema, path string, problems *[]string) {
+	report := func(format string, args ...interface{}) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.Types) > 0 {
+		if len(writer.Types) == 0 {
+			report("type restricted to %s", strings.Join(s.Types, " or "))
+		}
+		for _, t := range writer.Types {
+			if !typeAllowed(s.Types, t) {
+				report("type %s no longer accepted", t)
+			}
+		}
+	}
+
+	if s.Enum != nil {
+		if writer.Enum == nil {
+			report("enum added")
+		}
+		for _, v := range writer.Enum {
+			if !inEnum(s.Enum, v) {
+				report("enum value %v no longer accepted", v)
+			}
+		}
+	}
+
+	writerRequired := make(map[string]bool, len(writer.Required))
+	for _, name := range writer.Required {
+		writerRequired[name] = true
+	}
+	for _, name := range s.Required {
+		if !writerRequired[name] {
+			report("property %s became required", name)
+		}
+	}
+
+	for name, sub := range s.Properties {
+		if w, ok := writer.Properties[name]; ok {
+			sub.accepts(w, path+"."+name, problems)
+		}
+	}
+	if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+		if writer.AdditionalProperties == nil || *writer.AdditionalProperties {
+			report("additional properties no longer accepted")
+		}
+		for name := range writer.Properties {
+			if _, ok := s.Properties[name]; !ok {
+				report("property %s no longer accepted", name)
+			}
+		}
+	}
+
+	if s.Items != nil && writer.Items != nil {
+		s.Items.accepts(writer.Items, path+"[]", problems)
+	} else if s.Items != nil {
+		report("items restricted")
+	}
+
+	looser := func(name string, reader, writer *float64, lower bool) {
+		switch {
+		case reader == nil:
+		case writer == nil:
+			report("%s added", name)
+		case lower && *reader > *writer, !lower && *reader < *writer:
+			report("%s tightened from %v to %v", name, *writer, *reader)
+		}
+	}
+	looser("minimum", s.Minimum, writer.Minimum, true)
+	looser("maximum", s.Maximum, writer.Maximum, false)
+	looser("minLength", s.MinLength, writer.MinLength, true)
+	looser("maxLength", s.MaxLength, writer.MaxLength, false)
+	looser("minItems", s.MinItems, writer.MinItems, true)
+	looser("maxItems", s.MaxItems, writer.MaxItems, false)
+	if s.Pattern != nil && (writer.Pattern == nil || writer.Pattern.String() != s.Pattern.String()) {
+		report("pattern changed")
+	}
+}
+
+// SchemaVersion is one registered version of a topic's schema
+type SchemaVersion struct {
+	Version      int             `json:"version"`
+	Schema       json.RawMessage `json:"schema"`
+	RegisteredAt time.Time       `json:"registered_at"`
+	compiled     *Schema
+}
+
+// SchemaValidationError is returned when a payload does not match the
+// topic's current schema
+type SchemaValidationError struct {
+	Topic    string
+	Version  int
+	Problems []string
+}
+
+func (e *SchemaValidationError) Error() string {
+	return fmt.Sprintf("payload rejected by schema v%d of topic %s: %s", e.Version, e.Topic, strings.Join(e.Problems, "; "))
+}
+
+// SchemaCompatibilityError is returned when a new schema version breaks
+// the topic's compatibility mode
+type SchemaCompatibilityError struct {
+	Topic         string
+	Compatibility SchemaCompatibility
+	Problems      []string
+}
+
+func (e *SchemaCompatibilityError) Error() string {
+	return fmt.Sprintf("schema not %s compatible with the latest version of topic %s: %s", e.Compatibility, e.Topic, strings.Join(e.Problems, "; "))
+}
+
+// schemaRegistry holds the versions of one topic's schema
+type schemaRegistry struct {
+	versions      []SchemaVersion
+	compatibility SchemaCompatibility
+	mu            sync.RWMutex
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{compatibility: CompatibilityBackward}
+}
+
+// latest returns the current version, false if none is registered
+func (r *schemaRegistry) latest() (SchemaVersion, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.versions) == 0 {
+		return SchemaVersion{}, false
+	}
+	return r.versions[len(r.versions)-1], true
+}
+
+// register checks doc against the compatibility mode and adds it as the next version
+func (r *schemaRegistry) register(topic string, doc []byte, check bool, at time.Time) (SchemaVersion, error) {
+	compiled, err := ParseSchema(doc)
+	if err != nil {
+		return SchemaVersion{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n := len(r.versions); n > 0 && check {
+		prev := r.versions[n-1].compiled
+		var problems []string
+		if r.compatibility == CompatibilityBackward || r.compatibility == CompatibilityFull {
+			compiled.accepts(prev, "$", &problems)
+		}
+		if r.compatibility == CompatibilityForward || r.compatibility == CompatibilityFull {
+			prev.accepts(compiled, "$", &problems)
+		}
+		if len(problems) > 0 {
+			return SchemaVersion{}, &SchemaCompatibilityError{Topic: topic, Compatibility: r.compatibility, Problems: problems}
+		}
+	}
+
+	v := SchemaVersion{
+		Version:      len(r.versions) + 1,
+		Schema:       append(json.RawMessage(nil), doc...),
+		RegisteredAt: at,
+		compiled:     compiled,
+	}
+	r.versions = append(r.versions, v)
+	return v, nil
+}
+
+// RegisterSchema adds a new version of the topic's JSON Schema, checked
+// against the latest version under the topic's compatibility mode. From then
+// on payloads published to the topic must validate against it
+func (t *Topic) RegisterSchema(doc []byte) (SchemaVersion, error) {
+	v, err := t.schemas.register(t.Name, doc, true, time.Now())
+	if err != nil {
+		return SchemaVersion{}, err
+	}
+	t.journalSchema(&WALEntry{Operation: OpRegisterSchema, Topic: t.Name, Schema: v.Schema})
+	return v, nil
+}
+
+// Schema returns the topic's latest schema version, false if it has none
+func (t *Topic) Schema() (SchemaVersion, bool) {
+	return t.schemas.latest()
+}
+
+// SchemaVersions returns every registered version, oldest first
+func (t *Topic) SchemaVersions() []SchemaVersion {
+	t.schemas.mu.RLock()
+	defer t.schemas.mu.RUnlock()
+	return append([]SchemaVersion(nil), t.schemas.versions...)
+}
+
+// SchemaCompatibility returns the mode new schema versions are checked with
+func (t *Topic) SchemaCompatibility() SchemaCompatibility {
+	t.schemas.mu.RLock()
+	defer t.schemas.mu.RUnlock()
+	return t.schemas.compatibility
+}
+
+// SetSchemaCompatibility changes the mode new schema versions are checked with
+func (t *Topic) SetSchemaCompatibility(c SchemaCompatibility) error {
+	if !c.valid() {
+		return fmt.Errorf("unknown schema compatibility %q", c)
+	}
+	t.schemas.mu.Lock()
+	t.schemas.compatibility = c
+	t.schemas.mu.Unlock()
+
+	t.journalSchema(&WALEntry{Operation: OpSchemaCompatibility, Topic: t.Name, SchemaCompatibility: c})
+	return nil
+}
+
+// validateSchema checks msg against the latest schema and stamps the version
+// into its headers, a no-op for topics without a schema
+func (t *Topic) validateSchema(msg *Message) error {
+	v, ok := t.schemas.latest()
+	if !ok {
+		return nil
+	}
+	payload, err := msg.decodedPayload()
+	if err != nil {
+		return fmt.Errorf("topic %s: %w", t.Name, err)
+	}
+	if problems := v.compiled.Validate(payload); len(problems) > 0 {
+		return &SchemaValidationError{Topic: t.Name, Version: v.Version, Problems: problems}
+	}
+
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string, 1)
+	}
+	msg.Headers[SchemaVersionHeader] = strconv.Itoa(v.Version)
+	return nil
+}
+
+func (t *Topic) journalSchema(entry *WALEntry) {
+	if t.journal == nil {
+		return
+	}
+	if err := t.journal(entry); err != nil {
+		log.Printf("[Topic %s] Failed to journal %s: %v", t.Name, entry.Operation, err)
+	}
+}
+
+// replaySchema applies a schema WAL entry during recovery, versions were
+// checked when first registered
+func (t *Topic) replaySchema(entry *WALEntry) error {
+	switch entry.Operation {
+	case OpRegisterSchema:
+		_, err := t.schemas.register(t.Name, entry.Schema, false, time.Unix(0, entry.Timestamp))
+		return err
+	case OpSchemaCompatibility:
+		if !entry.SchemaCompatibility.valid() {
+			return fmt.Errorf("unknown schema compatibility %q", entry.SchemaCompatibility)
+		}
+		t.schemas.mu.Lock()
+		t.schemas.compatibility = entry.SchemaCompatibility
+		t.schemas.mu.Unlock()
+	}
+	return nil
+}
+
+// RegisterSchema registers a new schema version on a topic, see Topic.RegisterSchema
+func (mq *LpacaMQ) RegisterSchema(topicName string, doc []byte) (SchemaVersion, error) {
+	topic, err := mq.getOrCreateTopic(topicName)
+	if err != nil {
+		return SchemaVersion{}, err
+	}
+	return topic.RegisterSchema(doc)
+}
@@ -0,0 +1,143 @@
+package lpacamq
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const orderSchemaV1 = `{
+	"type": "object",
+	"properties": {
+		"id": {"type": "string", "minLength": 1},
+		"amount": {"type": "number", "minimum": 0},
+		"status": {"enum": ["new", "paid"]}
+	},
+	"required": ["id", "amount"]
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(orderSchemaV1))
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %v", err)
+	}
+
+	tests := []struct {
+		payload  string
+		problems int
+	}{
+		{`{"id": "o-1", "amount": 10}`, 0},
+		{`{"id": "o-1", "amount": 10, "status": "paid"}`, 0},
+		{`{"id": "", "amount": -1}`, 2},
+		{`{"amount": "ten"}`, 2},
+		{`{"id": "o-1", "amount": 1, "status": "lost"}`, 1},
+		{`[1, 2]`, 1},
+		{`not json`, 1},
+	}
+	for _, tt := range tests {
+		if problems := schema.Validate([]byte(tt.payload)); len(problems) != tt.problems {
+			t.Errorf("Validate(%s): expected %d problems, got %v", tt.payload, tt.problems, problems)
+		}
+	}
+
+	if _, err := ParseSchema([]byte(`{"type": "decimal"}`)); err == nil {
+		t.Error("Expected an unknown type to be rejected")
+	}
+}
+
+func TestSchemaPublishValidation(t *testing.T) {
+	mq := New()
+	v, err := mq.RegisterSchema("orders", []byte(orderSchemaV1))
+	if err != nil || v.Version != 1 {
+		t.Fatalf("RegisterSchema failed: %v", err)
+	}
+
+	_, err = mq.Publish("orders", []byte(`{"id": "o-1"}`))
+	var invalid *SchemaValidationError
+	if !errors.As(err, &invalid) || invalid.Version != 1 || !strings.Contains(err.Error(), "amount") {
+		t.Fatalf("Expected a validation error naming amount, got %v", err)
+	}
+
+	headers := map[string]string{"source": "web"}
+	msg, err := mq.Publish("orders", []byte(`{"id": "o-1", "amount": 5}`), WithHeaders(headers))
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if msg.Headers[SchemaVersionHeader] != "1" || msg.Headers["source"] != "web" {
+		t.Errorf("Expected the schema version stamped, got %v", msg.Headers)
+	}
+	if _, ok := headers[SchemaVersionHeader]; ok {
+		t.Error("Expected the caller's headers map to be left alone")
+	}
+}
+
+func TestSchemaCompatibility(t *testing.T) {
+	mq := New()
+	mq.RegisterSchema("orders", []byte(orderSchemaV1))
+	topic, _ := mq.GetTopic("orders")
+
+	// Backward (default): a new required field breaks old data
+	_, err := topic.RegisterSchema([]byte(`{"type": "object", "required": ["id", "amount", "currency"]}`))
+	var incompatible *SchemaCompatibilityError
+	if !errors.As(err, &incompatible) {
+		t.Fatalf("Expected a compatibility error, got %v", err)
+	}
+
+	// Backward: an optional field and a looser minimum are fine
+	v2 := `{
+		"type": "object",
+		"properties": {
+			"id": {"type": "string"},
+			"amount": {"type": "number"},
+			"status": {"enum": ["new", "paid", "refunded"]},
+			"currency": {"type": "string"}
+		},
+		"required": ["id", "amount"]
+	}`
+	if v, err := topic.RegisterSchema([]byte(v2)); err != nil || v.Version != 2 {
+		t.Fatalf("Expected v2 accepted, got %v", err)
+	}
+
+	// Forward: the old schema must accept new data, so widening an enum fails
+	topic.SetSchemaCompatibility(CompatibilityForward)
+	v3 := strings.Replace(v2, `"refunded"]`, `"refunded", "void"]`, 1)
+	if _, err := topic.RegisterSchema([]byte(v3)); !errors.As(err, &incompatible) {
+		t.Errorf("Expected forward compatibility to fail, got %v", err)
+	}
+
+	topic.SetSchemaCompatibility(CompatibilityNone)
+	if _, err := topic.RegisterSchema([]byte(v3)); err != nil {
+		t.Errorf("Expected no checks with compatibility none, got %v", err)
+	}
+	if len(topic.SchemaVersions()) != 3 {
+		t.Errorf("Expected 3 versions, got %d", len(topic.SchemaVersions()))
+	}
+}
+
+func TestSchemaRecovery(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+
+	mq := New()
+	mq.AttachWAL(wal)
+	mq.RegisterSchema("orders", []byte(orderSchemaV1))
+	topic, _ := mq.GetTopic("orders")
+	topic.SetSchemaCompatibility(CompatibilityFull)
+	wal.Close()
+
+	wal, _ = NewWAL(dir)
+	defer wal.Close()
+	restarted := New()
+	restarted.AttachWAL(wal)
+
+	topic, err := restarted.GetTopic("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := topic.Schema(); !ok || v.Version != 1 || topic.SchemaCompatibility() != CompatibilityFull {
+		t.Errorf("Expected the schema and compatibility restored")
+	}
+	if _, err := restarted.Publish("orders", []byte(`{}`)); err == nil {
+		t.Error("Expected the restored schema to validate publishes")
+	}
+}
@@ -2,12 +2,56 @@ package lpacamq
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	defaultBrowseLimit = 100
+	payloadPreviewLen  = 128
+)
+
+// MessageView is the read-only summary of a queued message returned by
+// browsing. Size and Preview describe the payload as published, decompressed
+// and loaded from the blob store, the headers still show how it is stored
+type MessageView struct {
+	ID         string            `json:"id"`
+	Timestamp  time.Time         `json:"timestamp"`
+	RetryCount int               `json:"retry_count"`
+	GroupID    string            `json:"group_id,omitempty"`
+	Partition  int               `json:"partition"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Size       int               `json:"size"`
+	Preview    string            `json:"preview"`
+}
+
+func newMessageView(msg *Message, store *BlobStore) MessageView {
+	payload, err := plainPayload(msg, store)
+	if err != nil {
+		log.Printf("[Server] No preview of message %s: %v", msg.ID, err)
+	}
+	preview := payload
+	if len(preview) > payloadPreviewLen {
+		preview = preview[:payloadPreviewLen]
+	}
+	return MessageView{
+		ID:         msg.ID,
+		Timestamp:  msg.Timestamp,
+		RetryCount: msg.RetryCount,
+		GroupID:    msg.GroupID,
+		Partition:  msg.Partition,
+		Headers:    msg.Headers,
+		Size:       len(payload),
+		Preview:    string(preview),
+	}
+}
+
 type Server struct {
 	mq     *LpacaMQ
 	mux    *http.ServeMux
@@ -32,9 +76,67 @@ func NewServer(mq *LpacaMQ, addr string) *Server {
 
 func (s *Server) routes() {
 	s.mux.HandleFunc("/publish", s.handlePublish)
+	s.mux.HandleFunc("/publish/batch", s.handlePublishBatch)
 	s.mux.HandleFunc("/subscribe/", s.handleSubscribe)
 	s.mux.HandleFunc("/topics", s.handleListTopics)
+	s.mux.HandleFunc("/topics/", s.handleTopic)
+	s.mux.HandleFunc("/exchanges", s.handleListExchanges)
+	s.mux.HandleFunc("/exchanges/", s.handleExchange)
 	s.mux.HandleFunc("/stats", s.handleStats)
+	s.mux.HandleFunc("/bridges", s.handleListBridges)
+	s.mux.HandleFunc("/bridges/", s.handleBridge)
+	s.mux.HandleFunc("/vhosts", s.handleListVhosts)
+	s.mux.HandleFunc("/vhosts/", s.handleVhost)
+}
+
+// publishRequest is the body of /publish and one line of /publish/batch
+type publishRequest struct {
+	Topic          string            `json:"topic"`
+	Payload        string            `json:"payload"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
+	GroupID        string            `json:"group_id,omitempty"`
+	Key            string            `json:"key,omitempty"`
+	Compression    string            `json:"compression,omitempty"` // gzip or deflate
+}
+
+// options turns the request into publish options, key and traceparent come
+// from HTTP headers and apply unless the request carries its own
+func (req *publishRequest) options(key, traceparent string) []PublishOption {
+	if req.IdempotencyKey != "" {
+		key = req.IdempotencyKey
+	}
+	
+	headers := req.Headers
+	if tp := traceparent; tp != "" && headers[TraceparentHeader] == "" {
+		headers = copyHeaders(headers)
+		if headers == nil {
+			headers = make(map[string]string, 1)
+		}
+		headers[TraceparentHeader] = tp
+	}
+	
+	return []PublishOption{WithIdempotencyKey(key), WithGroupID(req.GroupID), WithKey(req.Key), WithHeaders(headers), WithCompression(req.Compression)}
+}
+
+// publishError writes the status for a failed publish
+func publishError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var invalid *SchemaValidationError
+	var notFound *TopicNotFoundError
+	switch {
+	case errors.As(err, &invalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrUnsupportedEncoding):
+		status = http.StatusUnsupportedMediaType
+	case errors.As(err, &notFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrQuotaExceeded):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrQueueFull):
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
 }
 
 func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request) {
@@ -43,30 +145,71 @@ func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	var req struct {
-		Topic   string            `json:"topic"`
-		Payload string            `json:"payload"`
-		Headers map[string]string `json:"headers,omitempty"`
-	}
-	
+	var req publishRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	
-	msg, err := s.mq.Publish(req.Topic, []byte(req.Payload))
+	// The Idempotency-Key and traceparent request headers are honoured too,
+	// for retrying and traced HTTP clients
+	msg, err := s.mq.Publish(req.Topic, []byte(req.Payload), req.options(r.Header.Get("Idempotency-Key"), r.Header.Get(TraceparentHeader))...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		publishError(w, err)
 		return
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
+	if tp := msg.Headers[TraceparentHeader]; tp != "" {
+		w.Header().Set(TraceparentHeader, tp) // duplicates carry no headers
+	}
 	json.NewEncoder(w).Encode(map[string]string{
 		"id":    msg.ID,
 		"topic": msg.Topic,
 	})
 }
 
+// handlePublishBatch serves POST /publish/batch: an NDJSON body of
+// /publish requests, published all together or not at all
+func (s *Server) handlePublishBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	var batch []BatchMessage
+	dec := json.NewDecoder(r.Body)
+	for {
+		var req publishRequest
+		err := dec.Decode(&req)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("line %d: %v", len(batch)+1, err), http.StatusBadRequest)
+			return
+		}
+		batch = append(batch, BatchMessage{Topic: req.Topic, Payload: []byte(req.Payload), Options: req.options("", r.Header.Get(TraceparentHeader))})
+	}
+	if len(batch) == 0 {
+		http.Error(w, "Empty batch", http.StatusBadRequest)
+		return
+	}
+	
+	msgs, err := s.mq.PublishBatchMulti(batch)
+	if err != nil {
+		publishError(w, err)
+		return
+	}
+	
+	out := make([]map[string]string, len(msgs))
+	for i, msg := range msgs {
+		out[i] = map[string]string{"id": msg.ID, "topic": msg.Topic}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
 func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -92,32 +235,949 @@ func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	queue, err := s.mq.Subscribe(topic)
+	// ?group= joins a consumer group, ?subscription= is accepted as an alias
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		group = r.URL.Query().Get("subscription")
+	}
+	
+	// ?filter= is evaluated by the broker before delivery, without a group
+	// the stream gets a private subscription for the life of the connection
+	filter := r.URL.Query().Get("filter")
+	if filter != "" {
+		if _, err := ParseFilter(filter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	
+	// ?raw=true streams compressed payloads as published
+	raw := r.URL.Query().Get("raw") == "true"
+	
+	if IsTopicPattern(topic) {
+		s.streamPattern(w, r, flusher, topic, group, filter, raw)
+		return
+	}
+	
+	private := filter != "" && group == ""
+	if private {
+		group = "sse-" + GenerateID()
+	}
+	
+	opts := []SubscribeOption{WithGroup(group), WithFilter(filter)}
+	if private {
+		opts = append(opts, ephemeral())
+	}
+	queue, err := s.mq.Subscribe(topic, opts...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if private {
+		defer func() {
+			if t, err := s.mq.GetTopic(topic); err == nil {
+				t.DeleteSubscription(group)
+			}
+		}()
+	}
 	
 	// Simple polling for demo (in production use WebSockets or proper SSE)
 	for {
-		msg, ok := queue.PopNonBlocking()
+		msg, ok := queue.checkout(nil)
 		if !ok {
 			flusher.Flush()
-			time.Sleep(100 * time.Millisecond)
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
 			continue
 		}
 		
-		data, _ := json.Marshal(msg)
-		fmt.Fprintf(w, "data: %s\n\n", data)
+		out := msg
+		if t, err := s.mq.GetTopic(topic); err == nil {
+			out, _ = t.hydrated(msg) // on failure the event still carries the blob-ref header
+		}
+		if !raw {
+			out, _ = out.decompressed() // likewise the content-encoding header
+		}
+		d := traceDelivery(s.mq.currentTracer(), msg, map[string]string{"stream": "sse"})
+		data, _ := json.Marshal(out)
+		_, err := fmt.Fprintf(w, "data: %s\n\n", data)
 		flusher.Flush()
+		d.delivered(err)
+		ack := d.settle("ack")
+		queue.Release(msg) // SSE delivery is fire-and-forget
+		ack.End(nil)
+	}
+}
+
+// handleTopic serves /topics/{name}/...
+func (s *Server) handleTopic(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/topics/"), "/"), "/")
+	if parts[0] == "" {
+		http.Error(w, "Topic required", http.StatusBadRequest)
+		return
+	}
+
+	if len(parts) == 1 {
+		s.handleTopicConfig(w, r, parts[0])
+		return
+	}
+
+	topic, err := s.mq.GetTopic(parts[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "messages" && r.Method == http.MethodDelete:
+		s.handleRemoveMessages(w, r, topic)
+	case len(parts) == 2 && parts[1] == "messages":
+		s.handleTopicMessages(w, r, topic)
+	case len(parts) == 3 && parts[1] == "messages":
+		s.handleRemoveMessage(w, r, topic, parts[2])
+	case len(parts) == 2 && parts[1] == "groups":
+		s.handleGroups(w, r, topic)
+	case len(parts) == 2 && parts[1] == "dedup":
+		s.handleDedup(w, r, topic)
+	case len(parts) == 2 && parts[1] == "receive":
+		s.handleReceive(w, r, topic)
+	case len(parts) == 3 && parts[1] == "receipts":
+		s.handleReceipt(w, r, topic, parts[2])
+	case len(parts) == 2 && parts[1] == "schemas":
+		s.handleSchemas(w, r, topic)
+	case len(parts) == 3 && parts[1] == "schemas" && parts[2] == "compatibility":
+		s.handleSchemaCompatibility(w, r, topic)
+	case len(parts) == 3 && parts[1] == "schemas":
+		s.handleSchemaVersion(w, r, topic, parts[2])
+	default:
+		http.NotFound(w, r)
 	}
 }
 
+// handleSchemas serves GET /topics/{name}/schemas, listing every version,
+// and POST /topics/{name}/schemas, registering the JSON Schema in the body
+func (s *Server) handleSchemas(w http.ResponseWriter, r *http.Request, topic *Topic) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"topic":         topic.Name,
+			"compatibility": topic.SchemaCompatibility(),
+			"versions":      topic.SchemaVersions(),
+		})
+	case http.MethodPost:
+		var doc json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v, err := topic.RegisterSchema(doc)
+		var incompatible *SchemaCompatibilityError
+		if errors.As(err, &incompatible) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(v)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSchemaVersion serves GET /topics/{name}/schemas/{version|latest}
+func (s *Server) handleSchemaVersion(w http.ResponseWriter, r *http.Request, topic *Topic, version string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	versions := topic.SchemaVersions()
+	n := len(versions)
+	if version != "latest" {
+		var err error
+		if n, err = strconv.Atoi(version); err != nil {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+	}
+	if n < 1 || n > len(versions) {
+		http.Error(w, "Schema version not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions[n-1])
+}
+
+// handleSchemaCompatibility serves GET and PUT /topics/{name}/schemas/compatibility
+func (s *Server) handleSchemaCompatibility(w http.ResponseWriter, r *http.Request, topic *Topic) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Compatibility SchemaCompatibility `json:"compatibility"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := topic.SetSchemaCompatibility(req.Compatibility); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]SchemaCompatibility{"compatibility": topic.SchemaCompatibility()})
+}
+
+// TopicConfigView is the JSON form of a TopicConfig, durations are Go
+// duration strings such as "30s"
+type TopicConfigView struct {
+	MaxDepth       int            `json:"max_depth"`
+	OverflowPolicy OverflowPolicy `json:"overflow_policy"`
+	TTL            string         `json:"ttl"`
+	MaxRetries     int            `json:"max_retries"`
+	RetryBackoff   string         `json:"retry_backoff"`
+	Retention      string         `json:"retention"`
+	Persistent     bool           `json:"persistent"`
+	Partitions     int            `json:"partitions"`
+}
+
+func newTopicConfigView(cfg TopicConfig) TopicConfigView {
+	return TopicConfigView{
+		MaxDepth:       cfg.MaxDepth,
+		OverflowPolicy: cfg.OverflowPolicy,
+		TTL:            cfg.TTL.String(),
+		MaxRetries:     cfg.MaxRetries,
+		RetryBackoff:   cfg.RetryBackoff.String(),
+		Retention:      cfg.Retention.String(),
+		Persistent:     cfg.Persistent,
+		Partitions:     cfg.Partitions,
+	}
+}
+
+// topicConfigRequest is a partial update, omitted fields keep their value
+type topicConfigRequest struct {
+	MaxDepth       *int            `json:"max_depth"`
+	OverflowPolicy *OverflowPolicy `json:"overflow_policy"`
+	TTL            *string         `json:"ttl"`
+	MaxRetries     *int            `json:"max_retries"`
+	RetryBackoff   *string         `json:"retry_backoff"`
+	Retention      *string         `json:"retention"`
+	Persistent     *bool           `json:"persistent"`
+	Partitions     *int            `json:"partitions"`
+}
+
+func (req *topicConfigRequest) apply(cfg *TopicConfig) error {
+	if req.MaxDepth != nil {
+		cfg.MaxDepth = *req.MaxDepth
+	}
+	if req.OverflowPolicy != nil {
+		cfg.OverflowPolicy = *req.OverflowPolicy
+	}
+	if req.MaxRetries != nil {
+		cfg.MaxRetries = *req.MaxRetries
+	}
+	if req.Persistent != nil {
+		cfg.Persistent = *req.Persistent
+	}
+	if req.Partitions != nil {
+		cfg.Partitions = *req.Partitions
+	}
+
+	durations := []struct {
+		name string
+		src  *string
+		dst  *time.Duration
+	}{
+		{"ttl", req.TTL, &cfg.TTL},
+		{"retry_backoff", req.RetryBackoff, &cfg.RetryBackoff},
+		{"retention", req.Retention, &cfg.Retention},
+	}
+	for _, d := range durations {
+		if d.src == nil {
+			continue
+		}
+		v, err := time.ParseDuration(*d.src)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", d.name, err)
+		}
+		*d.dst = v
+	}
+	return nil
+}
+
+// handleTopicConfig serves GET /topics/{name} and PUT /topics/{name}, PUT
+// creates the topic if it does not exist yet
+func (s *Server) handleTopicConfig(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		topic, err := s.mq.GetTopic(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":   topic.Name,
+			"depth":  topic.Len(),
+			"config": newTopicConfigView(topic.Config()),
+		})
+	case http.MethodPut:
+		var req topicConfigRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		topic, err := s.mq.GetTopic(name)
+		cfg := DefaultTopicConfig()
+		if err == nil {
+			cfg = topic.Config()
+		}
+		if err := req.apply(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status := http.StatusOK
+		if topic == nil {
+			err = s.mq.CreateTopicWithConfig(name, cfg)
+			status = http.StatusCreated
+		} else {
+			err = s.mq.UpdateTopicConfig(name, cfg)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		topic, _ = s.mq.GetTopic(name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(newTopicConfigView(topic.Config()))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTopicMessages serves GET /topics/{name}/messages?peek=true&offset=&limit=
+// subscription=<name> (or group=<name>) browses a named subscription instead
+// of the default queue
+func (s *Server) handleTopicMessages(w http.ResponseWriter, r *http.Request, topic *Topic) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("peek") != "true" {
+		http.Error(w, "Only peek=true is supported, use /subscribe to consume", http.StatusBadRequest)
+		return
+	}
+
+	offset, err := queryInt(query.Get("offset"), 0)
+	if err != nil {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(query.Get("limit"), defaultBrowseLimit)
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	sub := query.Get("subscription")
+	if sub == "" {
+		sub = query.Get("group")
+	}
+	queue, err := topic.browseQueue(sub)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	msgs := queue.Browse(offset, limit)
+	views := make([]MessageView, 0, len(msgs))
+	for _, msg := range msgs {
+		views = append(views, newMessageView(msg, topic.blobStore()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"topic":        topic.Name,
+		"subscription": sub,
+		"depth":        queue.Len(),
+		"offset":       offset,
+		"messages":     views,
+	})
+}
+
+// handleRemoveMessages serves DELETE /topics/{name}/messages
+// with no query it purges the topic and all its subscriptions, older_than=<duration>,
+// group=<id> and header=<name>:<value> (repeatable) narrow the removal to
+// matching messages
+func (s *Server) handleRemoveMessages(w http.ResponseWriter, r *http.Request, topic *Topic) {
+	query := r.URL.Query()
+
+	var filters []MessageFilter
+	if v := query.Get("older_than"); v != "" {
+		age, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, "Invalid older_than", http.StatusBadRequest)
+			return
+		}
+		filters = append(filters, OlderThan(age))
+	}
+	if v := query.Get("group"); v != "" {
+		filters = append(filters, InGroup(v))
+	}
+	for _, v := range query["header"] {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || name == "" {
+			http.Error(w, "Invalid header, expected name:value", http.StatusBadRequest)
+			return
+		}
+		filters = append(filters, HasHeader(name, value))
+	}
+
+	var removed int
+	if len(filters) == 0 {
+		removed = topic.Purge()
+	} else {
+		removed = topic.RemoveIf(func(msg *Message) bool {
+			for _, f := range filters {
+				if !f(msg) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"topic":   topic.Name,
+		"removed": removed,
+	})
+}
+
+// handleRemoveMessage serves DELETE /topics/{name}/messages/{id}
+func (s *Server) handleRemoveMessage(w http.ResponseWriter, r *http.Request, topic *Topic, id string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	removed := topic.RemoveByID(id)
+	if removed == 0 {
+		http.Error(w, fmt.Sprintf("message %s not queued", id), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"topic":   topic.Name,
+		"removed": removed,
+	})
+}
+
+// handleReceive serves POST /topics/{name}/receive?visibility=30s&max=10
+// messages are hidden rather than consumed until deleted by receipt handle
+func (s *Server) handleReceive(w http.ResponseWriter, r *http.Request, topic *Topic) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	visibility := DefaultVisibilityTimeout
+	if v := query.Get("visibility"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid visibility", http.StatusBadRequest)
+			return
+		}
+		visibility = d
+	}
+	max, err := queryInt(query.Get("max"), 1)
+	if err != nil || max == 0 {
+		http.Error(w, "Invalid max", http.StatusBadRequest)
+		return
+	}
+
+	raw := query.Get("raw") == "true" // leave compressed payloads as published
+	
+	type received struct {
+		ReceiptHandle string   `json:"receipt_handle"`
+		Message       *Message `json:"message"`
+	}
+	out := make([]received, 0, max)
+	for len(out) < max {
+		msg, receipt, ok := topic.receive(visibility, raw)
+		if !ok {
+			break
+		}
+		out = append(out, received{ReceiptHandle: receipt, Message: msg})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleReceipt serves DELETE /topics/{name}/receipts/{handle} to delete a
+// received message and PUT /topics/{name}/receipts/{handle}?visibility=60s
+// to change its visibility timeout
+func (s *Server) handleReceipt(w http.ResponseWriter, r *http.Request, topic *Topic, receipt string) {
+	var err error
+	switch r.Method {
+	case http.MethodDelete:
+		err = topic.Delete(receipt)
+	case http.MethodPut:
+		d, perr := time.ParseDuration(r.URL.Query().Get("visibility"))
+		if perr != nil || d < 0 {
+			http.Error(w, "Invalid visibility", http.StatusBadRequest)
+			return
+		}
+		err = topic.ChangeVisibility(receipt, d)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGroups serves GET /topics/{name}/groups
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request, topic *Topic) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groups, err := s.mq.ConsumerGroups(topic.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// handleDedup serves GET and PUT /topics/{name}/dedup, the topic's
+// idempotency window. PUT is a partial update, a zero window or max_keys
+// removes that bound
+func (s *Server) handleDedup(w http.ResponseWriter, r *http.Request, topic *Topic) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Window  *string `json:"window"`
+			MaxKeys *int    `json:"max_keys"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ttl, maxKeys := topic.DedupWindow()
+		if req.Window != nil {
+			d, err := time.ParseDuration(*req.Window)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("window: %v", err), http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		if req.MaxKeys != nil {
+			maxKeys = *req.MaxKeys
+		}
+		if err := s.mq.SetDedupWindow(topic.Name, ttl, maxKeys); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ttl, maxKeys := topic.DedupWindow()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window":   ttl.String(),
+		"max_keys": maxKeys,
+	})
+}
+
+// queryInt parses a non-negative integer query value, using def when empty
+func queryInt(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value %q", v)
+	}
+	return n, nil
+}
+
+// streamPattern serves /subscribe/{pattern} by fanning every matching topic
+// into one SSE stream until the client goes away
+func (s *Server) streamPattern(w http.ResponseWriter, r *http.Request, flusher http.Flusher, pattern, group, filter string, raw bool) {
+	ctx := r.Context()
+	msgs := make(chan *Message)
+	opts := []SubscribeOption{WithGroup(group), WithFilter(filter)}
+	if raw {
+		opts = append(opts, WithRawPayload())
+	}
+
+	ps, err := s.mq.SubscribePattern(pattern, func(msg *Message) error {
+		select {
+		case msgs <- msg:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, opts...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer s.mq.UnsubscribePattern(ps.ID)
+	
+	flusher.Flush()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-msgs:
+			data, _ := json.Marshal(msg)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) handleListExchanges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.mq.ListExchanges())
+}
+
+// handleExchange serves /exchanges/{name}[/bindings|/publish]
+//
+//	PUT    /exchanges/{name}           {"type": "topic"}
+//	GET    /exchanges/{name}
+//	DELETE /exchanges/{name}
+//	GET    /exchanges/{name}/bindings
+//	POST   /exchanges/{name}/bindings  Binding
+//	DELETE /exchanges/{name}/bindings  Binding
+//	POST   /exchanges/{name}/publish   {"routing_key", "payload", "headers"}
+func (s *Server) handleExchange(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/exchanges/"), "/"), "/")
+	name := parts[0]
+	if name == "" {
+		http.Error(w, "Exchange required", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		s.handleExchangeDefinition(w, r, name)
+	case len(parts) == 2 && parts[1] == "bindings":
+		s.handleBindings(w, r, name)
+	case len(parts) == 2 && parts[1] == "publish":
+		s.handleExchangePublish(w, r, name)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleExchangeDefinition(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Type ExchangeType `json:"type"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.mq.DeclareExchange(name, req.Type); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		ex, err := s.mq.GetExchange(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":     ex.Name,
+			"type":     ex.Type,
+			"bindings": ex.Bindings(),
+		})
+	case http.MethodDelete:
+		if err := s.mq.DeleteExchange(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleBindings(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method == http.MethodGet {
+		ex, err := s.mq.GetExchange(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ex.Bindings())
+		return
+	}
+
+	var b Binding
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.Exchange = name
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		err = s.mq.Bind(b)
+	case http.MethodDelete:
+		err = s.mq.Unbind(b)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleExchangePublish(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RoutingKey string            `json:"routing_key"`
+		Payload    string            `json:"payload"`
+		Headers    map[string]string `json:"headers,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msgs, err := s.mq.PublishToExchange(name, req.RoutingKey, []byte(req.Payload), WithHeaders(req.Headers))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	routed := make([]map[string]string, 0, len(msgs))
+	for _, msg := range msgs {
+		routed = append(routed, map[string]string{"id": msg.ID, "topic": msg.Topic})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(routed)
+}
+
 func (s *Server) handleListTopics(w http.ResponseWriter, r *http.Request) {
 	topics := s.mq.ListTopics()
 	json.NewEncoder(w).Encode(topics)
 }
 
+func (s *Server) handleListBridges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.mq.ListBridges())
+}
+
+// handleBridge serves GET /bridges/{name} with the bridge's stats and
+// DELETE /bridges/{name}, bridges are created from Go since they carry code
+func (s *Server) handleBridge(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/bridges/"), "/")
+	if name == "" {
+		http.Error(w, "Bridge required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		b, err := s.mq.GetBridge(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b.Stats())
+	case http.MethodDelete:
+		if err := s.mq.DeleteBridge(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleListVhosts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.mq.ListNamespaces())
+}
+
+// handleVhost serves /vhosts/{name} and, under /vhosts/{name}/..., the whole
+// API scoped to that namespace
+func (s *Server) handleVhost(w http.ResponseWriter, r *http.Request) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/vhosts/"), "/")
+	if name == "" {
+		http.Error(w, "Namespace required", http.StatusBadRequest)
+		return
+	}
+	if rest == "" {
+		s.handleVhostDefinition(w, r, name)
+		return
+	}
+
+	ns, err := s.mq.Namespace(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	scoped := &Server{mq: ns, mux: http.NewServeMux()}
+	scoped.routes()
+	http.StripPrefix("/vhosts/"+name, scoped.mux).ServeHTTP(w, r)
+}
+
+// NamespaceView is the JSON form of a namespace and its quotas
+type NamespaceView struct {
+	Name           string          `json:"name"`
+	Topics         int             `json:"topics"`
+	Consumers      int             `json:"consumers"`
+	MaxTopics      int             `json:"max_topics"`
+	MaxConsumers   int             `json:"max_consumers"`
+	MaxMessageSize int             `json:"max_message_size"`
+	TopicDefaults  TopicConfigView `json:"topic_defaults"`
+}
+
+func newNamespaceView(ns *LpacaMQ) NamespaceView {
+	cfg := ns.NamespaceConfig()
+	return NamespaceView{
+		Name:           ns.NamespaceName(),
+		Topics:         len(ns.ListTopics()),
+		Consumers:      ns.GetConsumerCount(),
+		MaxTopics:      cfg.MaxTopics,
+		MaxConsumers:   cfg.MaxConsumers,
+		MaxMessageSize: cfg.MaxMessageSize,
+		TopicDefaults:  newTopicConfigView(cfg.TopicDefaults),
+	}
+}
+
+// handleVhostDefinition serves GET, PUT and DELETE /vhosts/{name}, PUT
+// creates the namespace or updates the fields given
+func (s *Server) handleVhostDefinition(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		ns, err := s.mq.Namespace(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newNamespaceView(ns))
+	case http.MethodPut:
+		var req struct {
+			MaxTopics      *int                `json:"max_topics"`
+			MaxConsumers   *int                `json:"max_consumers"`
+			MaxMessageSize *int                `json:"max_message_size"`
+			TopicDefaults  *topicConfigRequest `json:"topic_defaults"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ns, err := s.mq.Namespace(name)
+		cfg := DefaultNamespaceConfig()
+		if err == nil {
+			cfg = ns.NamespaceConfig()
+		}
+		for _, q := range []struct {
+			src *int
+			dst *int
+		}{
+			{req.MaxTopics, &cfg.MaxTopics},
+			{req.MaxConsumers, &cfg.MaxConsumers},
+			{req.MaxMessageSize, &cfg.MaxMessageSize},
+		} {
+			if q.src != nil {
+				*q.dst = *q.src
+			}
+		}
+		if req.TopicDefaults != nil {
+			if err := req.TopicDefaults.apply(&cfg.TopicDefaults); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		status := http.StatusOK
+		if ns == nil {
+			ns, err = s.mq.CreateNamespace(name, cfg)
+			status = http.StatusCreated
+		} else {
+			err = s.mq.UpdateNamespace(name, cfg)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(newNamespaceView(ns))
+	case http.MethodDelete:
+		if err := s.mq.DeleteNamespace(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	stats := map[string]interface{}{
 		"topics": len(s.mq.ListTopics()),
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestServerPublish(t *testing.T) {
@@ -50,4 +52,423 @@ func TestServerListTopics(t *testing.T) {
 	if len(topics) != 2 {
 		t.Errorf("Expected 2 topics, got %d", len(topics))
 	}
-}
\ No newline at end of file
+}
+func TestServerPublishIdempotencyKey(t *testing.T) {
+	mq := New()
+	server := NewServer(mq, "localhost:0")
+
+	ids := make([]string, 2)
+	for i := range ids {
+		reqBody := `{"topic": "orders", "payload": "test-order"}`
+		req := httptest.NewRequest(http.MethodPost, "/publish", bytes.NewBufferString(reqBody))
+		req.Header.Set("Idempotency-Key", "order-42")
+
+		w := httptest.NewRecorder()
+		server.handlePublish(w, req)
+
+		var resp map[string]string
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		ids[i] = resp["id"]
+		if _, set := w.Result().Header[http.CanonicalHeaderKey(TraceparentHeader)]; set != (i == 0) {
+			t.Errorf("Publish %d: expected a traceparent header only on the first publish", i)
+		}
+	}
+
+	if ids[0] == "" || ids[0] != ids[1] {
+		t.Errorf("Expected the same ID twice, got %v", ids)
+	}
+}
+
+func TestServerPeekMessages(t *testing.T) {
+	mq := New()
+	mq.Publish("orders", []byte("order-1"))
+	mq.Publish("orders", []byte("order-2"))
+
+	server := NewServer(mq, "localhost:0")
+
+	req := httptest.NewRequest(http.MethodGet, "/topics/orders/messages?peek=true&limit=1", nil)
+	w := httptest.NewRecorder()
+	server.handleTopic(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Depth    int           `json:"depth"`
+		Messages []MessageView `json:"messages"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	if resp.Depth != 2 || len(resp.Messages) != 1 || resp.Messages[0].Preview != "order-1" {
+		t.Errorf("Unexpected peek response: %s", w.Body.String())
+	}
+
+	topic, _ := mq.GetTopic("orders")
+	if topic.Len() != 2 {
+		t.Errorf("Peek should not consume, got length %d", topic.Len())
+	}
+}
+
+func TestServerPeekSubscription(t *testing.T) {
+	mq := New()
+	mq.Subscribe("orders", WithGroup("billing"))
+	mq.Publish("orders", []byte("order-1"))
+	mq.Publish("orders", []byte("order-2"))
+
+	server := NewServer(mq, "localhost:0")
+
+	req := httptest.NewRequest(http.MethodGet, "/topics/orders/messages?peek=true&group=billing", nil)
+	w := httptest.NewRecorder()
+	server.handleTopic(w, req)
+
+	var resp struct {
+		Depth    int           `json:"depth"`
+		Messages []MessageView `json:"messages"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Depth != 2 || len(resp.Messages) != 2 {
+		t.Errorf("Expected the group's messages, got %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/topics/orders/messages?peek=true&group=missing", nil)
+	w = httptest.NewRecorder()
+	server.handleTopic(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown group, got %d", w.Code)
+	}
+}
+
+func TestServerRemoveMessages(t *testing.T) {
+	mq := New()
+	msg, _ := mq.Publish("orders", []byte("bad-order"))
+	mq.Publish("orders", []byte("order-2"))
+	mq.Publish("orders", []byte("order-3"))
+
+	server := NewServer(mq, "localhost:0")
+
+	req := httptest.NewRequest(http.MethodDelete, "/topics/orders/messages/"+msg.ID, nil)
+	w := httptest.NewRecorder()
+	server.handleTopic(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/topics/orders/messages", nil)
+	w = httptest.NewRecorder()
+	server.handleTopic(w, req)
+
+	var resp struct {
+		Removed int `json:"removed"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Removed != 2 {
+		t.Errorf("Expected purge to remove 2, got %d", resp.Removed)
+	}
+}
+
+func TestServerReceiveDelete(t *testing.T) {
+	mq := New()
+	mq.Publish("orders", []byte("order-1"))
+
+	server := NewServer(mq, "localhost:0")
+
+	req := httptest.NewRequest(http.MethodPost, "/topics/orders/receive?visibility=1m", nil)
+	w := httptest.NewRecorder()
+	server.handleTopic(w, req)
+
+	var received []struct {
+		ReceiptHandle string `json:"receipt_handle"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &received)
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 received message, got %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/topics/orders/receipts/"+received[0].ReceiptHandle, nil)
+	w = httptest.NewRecorder()
+	server.handleTopic(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestServerExchanges(t *testing.T) {
+	mq := New()
+	server := NewServer(mq, "localhost:0")
+
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPut, "/exchanges/events", `{"type": "direct"}`, http.StatusNoContent},
+		{http.MethodPost, "/exchanges/events/bindings", `{"topic": "orders", "routing_key": "order"}`, http.StatusNoContent},
+		{http.MethodPost, "/exchanges/events/publish", `{"routing_key": "order", "payload": "o-1"}`, http.StatusOK},
+	}
+	for _, step := range steps {
+		req := httptest.NewRequest(step.method, step.path, bytes.NewBufferString(step.body))
+		w := httptest.NewRecorder()
+		server.handleExchange(w, req)
+		if w.Code != step.want {
+			t.Fatalf("%s %s: expected %d, got %d: %s", step.method, step.path, step.want, w.Code, w.Body.String())
+		}
+	}
+
+	topic, err := mq.GetTopic("orders")
+	if err != nil || topic.Len() != 1 {
+		t.Errorf("Expected the message routed to orders")
+	}
+}
+
+func TestServerTopicConfig(t *testing.T) {
+	mq := New()
+	server := NewServer(mq, "localhost:0")
+
+	req := httptest.NewRequest(http.MethodPut, "/topics/orders", bytes.NewBufferString(`{"max_depth": 10, "ttl": "1m", "overflow_policy": "drop-oldest"}`))
+	w := httptest.NewRecorder()
+	server.handleTopic(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/topics/orders", bytes.NewBufferString(`{"max_retries": 3}`))
+	w = httptest.NewRecorder()
+	server.handleTopic(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	topic, _ := mq.GetTopic("orders")
+	cfg := topic.Config()
+	if cfg.MaxDepth != 10 || cfg.TTL != time.Minute || cfg.OverflowPolicy != OverflowDropOldest || cfg.MaxRetries != 3 {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/topics/orders", bytes.NewBufferString(`{"ttl": "soon"}`))
+	w = httptest.NewRecorder()
+	server.handleTopic(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad duration, got %d", w.Code)
+	}
+}
+
+func TestServerDedupWindow(t *testing.T) {
+	mq := New()
+	mq.CreateTopic("orders")
+	server := NewServer(mq, "localhost:0")
+
+	req := httptest.NewRequest(http.MethodPut, "/topics/orders/dedup", bytes.NewBufferString(`{"window": "1h"}`))
+	w := httptest.NewRecorder()
+	server.handleTopic(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	topic, _ := mq.GetTopic("orders")
+	if ttl, maxKeys := topic.DedupWindow(); ttl != time.Hour || maxKeys != DefaultDedupMaxKeys {
+		t.Errorf("Expected 1h and the default key limit, got %v/%d", ttl, maxKeys)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/topics/orders/dedup", bytes.NewBufferString(`{"max_keys": -1}`))
+	w = httptest.NewRecorder()
+	server.handleTopic(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative limit, got %d", w.Code)
+	}
+}
+
+func TestServerVhosts(t *testing.T) {
+	mq := New()
+	server := NewServer(mq, "localhost:0")
+
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPut, "/vhosts/team-a", `{"max_topics": 5, "topic_defaults": {"max_depth": 100}}`, http.StatusCreated},
+		{http.MethodPost, "/vhosts/team-a/publish", `{"topic": "orders", "payload": "a-order"}`, http.StatusOK},
+		{http.MethodGet, "/vhosts/team-a/topics/orders/messages?peek=true", "", http.StatusOK},
+		{http.MethodGet, "/topics/orders/messages?peek=true", "", http.StatusNotFound},
+		{http.MethodGet, "/vhosts/team-b/topics", "", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		req := httptest.NewRequest(step.method, step.path, bytes.NewBufferString(step.body))
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, req)
+		if w.Code != step.want {
+			t.Fatalf("%s %s: expected %d, got %d: %s", step.method, step.path, step.want, w.Code, w.Body.String())
+		}
+	}
+
+	ns, err := mq.Namespace("team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := ns.NamespaceConfig(); cfg.MaxTopics != 5 || cfg.TopicDefaults.MaxDepth != 100 {
+		t.Errorf("Unexpected namespace config %+v", cfg)
+	}
+	if topic, err := ns.GetTopic("orders"); err != nil || topic.Len() != 1 {
+		t.Errorf("Expected the message in team-a's orders")
+	}
+}
+
+func TestServerSchemas(t *testing.T) {
+	mq := New()
+	mq.CreateTopic("orders")
+	server := NewServer(mq, "localhost:0")
+
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/topics/orders/schemas", `{"type": "object", "required": ["id"]}`, http.StatusCreated},
+		{http.MethodPost, "/topics/orders/schemas", `{"type": "object", "required": ["id", "amount"]}`, http.StatusConflict},
+		{http.MethodGet, "/topics/orders/schemas/latest", "", http.StatusOK},
+		{http.MethodPut, "/topics/orders/schemas/compatibility", `{"compatibility": "full"}`, http.StatusOK},
+	}
+	for _, step := range steps {
+		req := httptest.NewRequest(step.method, step.path, bytes.NewBufferString(step.body))
+		w := httptest.NewRecorder()
+		server.handleTopic(w, req)
+		if w.Code != step.want {
+			t.Fatalf("%s %s: expected %d, got %d: %s", step.method, step.path, step.want, w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/publish", bytes.NewBufferString(`{"topic": "orders", "payload": "{\"amount\": 1}"}`))
+	w := httptest.NewRecorder()
+	server.handlePublish(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid payload, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestServerPublishHeaders(t *testing.T) {
+	mq := New()
+	server := NewServer(mq, "localhost:0")
+
+	for _, kind := range []string{"order", "refund", "order"} {
+		body := `{"topic": "orders", "payload": "x", "headers": {"kind": "` + kind + `"}}`
+		req := httptest.NewRequest(http.MethodPost, "/publish", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		server.handlePublish(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/topics/orders/messages?peek=true", nil)
+	w := httptest.NewRecorder()
+	server.handleTopic(w, req)
+	var browsed struct {
+		Messages []MessageView `json:"messages"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &browsed)
+	if len(browsed.Messages) != 3 || browsed.Messages[1].Headers["kind"] != "refund" {
+		t.Fatalf("Expected headers in the browse view, got %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/topics/orders/messages?header=kind:order", nil)
+	w = httptest.NewRecorder()
+	server.handleTopic(w, req)
+	topic, _ := mq.GetTopic("orders")
+	if topic.Len() != 1 {
+		t.Errorf("Expected only the refund left, got depth %d: %s", topic.Len(), w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/topics/orders/receive", nil)
+	w = httptest.NewRecorder()
+	server.handleTopic(w, req)
+	var received []struct {
+		Message *Message `json:"message"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &received)
+	if len(received) != 1 || received[0].Message.Headers["kind"] != "refund" {
+		t.Errorf("Expected headers in the pull response, got %s", w.Body.String())
+	}
+}
+
+func TestServerPublishTraceparent(t *testing.T) {
+	mq := New()
+	server := NewServer(mq, "localhost:0")
+
+	req := httptest.NewRequest(http.MethodPost, "/publish", bytes.NewBufferString(`{"topic": "traced", "payload": "x"}`))
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	server.handlePublish(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	tc, err := ParseTraceparent(w.Header().Get(TraceparentHeader))
+	if err != nil || tc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the response to continue the request's trace, got %q", w.Header().Get(TraceparentHeader))
+	}
+}
+
+func TestServerPublishBatch(t *testing.T) {
+	mq := New()
+	server := NewServer(mq, "localhost:0")
+
+	body := `{"topic": "orders", "payload": "o-1"}
+{"topic": "inventory", "payload": "i-1", "headers": {"sku": "42"}}
+{"topic": "orders", "payload": "o-2"}
+`
+	req := httptest.NewRequest(http.MethodPost, "/publish/batch", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	server.mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp []map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp) != 3 || resp[1]["topic"] != "inventory" {
+		t.Errorf("Unexpected response %s", w.Body.String())
+	}
+
+	orders, _ := mq.GetTopic("orders")
+	if orders.Len() != 2 {
+		t.Errorf("Expected 2 orders, got %d", orders.Len())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/publish/batch", bytes.NewBufferString(`{"topic": "orders", "payload": "o-3"}
+not json`))
+	w = httptest.NewRecorder()
+	server.mux.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || orders.Len() != 2 {
+		t.Errorf("Expected 400 and nothing published, got %d with depth %d", w.Code, orders.Len())
+	}
+}
+
+func TestServerPeekDecodesPayload(t *testing.T) {
+	payload := strings.Repeat("order-line ", 20)
+
+	compressed := New()
+	if err := compressed.SetCompression(EncodingGzip, 8); err != nil {
+		t.Fatalf("SetCompression failed: %v", err)
+	}
+	claimChecked := New()
+	if err := claimChecked.EnableClaimCheck(t.TempDir(), 8); err != nil {
+		t.Fatalf("EnableClaimCheck failed: %v", err)
+	}
+
+	for name, mq := range map[string]*LpacaMQ{"compressed": compressed, "claim-checked": claimChecked} {
+		mq.Publish("orders", []byte(payload))
+
+		req := httptest.NewRequest(http.MethodGet, "/topics/orders/messages?peek=true", nil)
+		w := httptest.NewRecorder()
+		NewServer(mq, "localhost:0").handleTopic(w, req)
+
+		var resp struct {
+			Messages []MessageView `json:"messages"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Messages) != 1 {
+			t.Fatalf("%s: expected one message, got %s", name, w.Body.String())
+		}
+		view := resp.Messages[0]
+		if view.Size != len(payload) || view.Preview != payload[:payloadPreviewLen] {
+			t.Errorf("%s: expected the published payload, got size %d preview %q", name, view.Size, view.Preview)
+		}
+	}
+}
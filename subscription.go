@@ -0,0 +1,38 @@
+package lpacamq
+
+import "sync/atomic"
+
+// Subscription is a named, independent view of a topic. Every message
+// published to the topic is copied into each subscription's own queue, so
+// each subscription sees the full stream at its own pace while consumers
+// attached to the same subscription compete for its messages
+type Subscription struct {
+	Name      string
+	Topic     string
+	queue     *Queue
+	filter    atomic.Pointer[Filter] // nil delivers everything
+	ephemeral bool                   // private to one subscriber, never journaled
+}
+
+func newSubscription(topic, name string) *Subscription {
+	return &Subscription{
+		Name:  name,
+		Topic: topic,
+		queue: NewQueue(),
+	}
+}
+
+// Queue returns the subscription's delivery queue
+func (s *Subscription) Queue() *Queue {
+	return s.queue
+}
+
+// Filter returns the subscription's filter, nil if it has none
+func (s *Subscription) Filter() *Filter {
+	return s.filter.Load()
+}
+
+// Len returns the number of messages waiting on the subscription
+func (s *Subscription) Len() int {
+	return s.queue.Len()
+}
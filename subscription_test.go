@@ -0,0 +1,141 @@
+package lpacamq
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionFanOut(t *testing.T) {
+	topic := NewTopic("orders")
+	topic.Subscribe() // the default queue gets copies once it is used
+	audit, err := topic.CreateSubscription("audit")
+	if err != nil {
+		t.Fatalf("CreateSubscription failed: %v", err)
+	}
+	billing, _ := topic.CreateSubscription("billing")
+
+	if _, err := topic.CreateSubscription("audit"); err == nil {
+		t.Error("Duplicate subscription should fail")
+	}
+
+	topic.Publish(NewMessage("orders", []byte("order-1")))
+
+	if audit.Len() != 1 || billing.Len() != 1 || topic.Len() != 1 {
+		t.Errorf("Every subscription should get a copy: audit %d billing %d default %d",
+			audit.Len(), billing.Len(), topic.Len())
+	}
+
+	// Consuming from one subscription leaves the others untouched
+	audit.Queue().PopNonBlocking()
+	if billing.Len() != 1 {
+		t.Errorf("Expected billing to keep its copy, got %d", billing.Len())
+	}
+
+	if err := topic.DeleteSubscription("billing"); err != nil {
+		t.Errorf("DeleteSubscription failed: %v", err)
+	}
+	if len(topic.Subscriptions()) != 1 {
+		t.Errorf("Expected 1 subscription left, got %v", topic.Subscriptions())
+	}
+}
+
+func TestSubscribeWithHandlerFanOut(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	var audit, orders int32
+	mq.SubscribeWithHandler("orders", func(msg *Message) error {
+		atomic.AddInt32(&audit, 1)
+		return nil
+	}, WithSubscription("audit"))
+	mq.SubscribeWithHandler("orders", func(msg *Message) error {
+		atomic.AddInt32(&orders, 1)
+		return nil
+	}, WithSubscription("orders-service"))
+
+	for i := 0; i < 10; i++ {
+		mq.Publish("orders", []byte("order"))
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if atomic.LoadInt32(&audit) != 10 || atomic.LoadInt32(&orders) != 10 {
+		t.Errorf("Both subscriptions should see every message: audit %d orders %d", audit, orders)
+	}
+}
+
+func TestSubscriptionRestoredFromWAL(t *testing.T) {
+	dir := "./test_wal_subscription"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+
+	mq.Subscribe("orders")
+	audit, _ := mq.Subscribe("orders", WithSubscription("audit"))
+	mq.Publish("orders", []byte("order-1"))
+
+	msg, _ := audit.PopNonBlocking()
+	audit.Release(msg) // audit is done, the default queue is not
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	mq2.AttachWAL(wal2)
+
+	topic, _ := mq2.GetTopic("orders")
+	sub, ok := topic.Subscription("audit")
+	if !ok {
+		t.Fatal("Subscription should be restored")
+	}
+	if sub.Len() != 0 {
+		t.Errorf("Acked message should not return to audit, got %d", sub.Len())
+	}
+	if topic.Len() != 1 {
+		t.Errorf("Unacked message should return to the default queue, got %d", topic.Len())
+	}
+}
+
+func TestGroupOnlyTopicSkipsDefaultQueue(t *testing.T) {
+	dir := "./test_wal_group_only"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	cfg := DefaultTopicConfig()
+	cfg.MaxDepth = 2
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+	mq.CreateTopicWithConfig("orders", cfg)
+
+	audit, _ := mq.Subscribe("orders", WithGroup("audit"))
+	for i := 0; i < 5; i++ {
+		if _, err := mq.Publish("orders", []byte("order")); err != nil {
+			t.Fatalf("Publish %d failed: %v", i, err)
+		}
+		msg, _ := audit.PopNonBlocking()
+		audit.Release(msg)
+	}
+
+	topic, _ := mq.GetTopic("orders")
+	if topic.Len() != 0 {
+		t.Errorf("Nobody reads the default queue, expected it empty, got %d", topic.Len())
+	}
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	if err := mq2.AttachWAL(wal2); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+	topic2, _ := mq2.GetTopic("orders")
+	if topic2.Len() != 0 {
+		t.Errorf("Expected no default queue copies after restart, got %d", topic2.Len())
+	}
+}
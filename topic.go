@@ -2,41 +2,409 @@ package lpacamq
 
 import (
 	"fmt"
+	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //represents named msg channel
+// queue is the default subscription shared by plain Subscribe callers,
+// named subscriptions each get their own copy of every message
 type Topic struct{
 	Name	string
 	queue	*Queue
+	queueUsed	bool // the default queue was subscribed to, it gets every message from then on
+	subs	map[string]*Subscription
+	router	*partitionRouter
+	config	TopicConfig
+	persistent	atomic.Bool // mirrors config.Persistent, read under queue locks
+	deadLetters	*Queue // messages that exhausted MaxRetries
+	schemas	*schemaRegistry
+	dedup	*dedupWindow
+	journal	func(*WALEntry) error // set by the broker to persist removals and acks
+	blobs	func() *BlobStore // set by the broker, claim-checked payloads live here
+	tracer	func() Tracer // set by the broker, nil reports no spans
 	mu		sync.RWMutex
 	closed	bool
 }
 
 func NewTopic(name string) *Topic{
-	return &Topic{
+	return NewPartitionedTopic(name, 1)
+}
+
+// NewPartitionedTopic creates a topic split into n partitions, messages are
+// ordered within a partition and partitions are consumed in parallel
+func NewPartitionedTopic(name string, n int) *Topic {
+	cfg := DefaultTopicConfig()
+	if n > 1 {
+		cfg.Partitions = n
+	}
+	t, _ := NewTopicWithConfig(name, cfg) // the defaults always validate
+	return t
+}
+
+// NewTopicWithConfig creates a topic with the given settings
+func NewTopicWithConfig(name string, cfg TopicConfig) (*Topic, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	t := &Topic{
 		Name: name,
 		queue: NewQueue(),
+		subs: make(map[string]*Subscription),
+		router: newPartitionRouter(cfg.Partitions),
+		config: cfg,
+		deadLetters: NewQueue(),
+		schemas: newSchemaRegistry(),
+		dedup: newDedupWindow(DefaultDedupWindow, DefaultDedupMaxKeys),
 	}
+	t.persistent.Store(cfg.Persistent)
+	t.wireQueue(t.queue, "")
+	t.queue.configure(cfg)
+	t.deadLetters.configure(deadLetterConfig(cfg))
+	// A dead letter holds its blob until it is consumed or dropped
+	t.deadLetters.onRelease = func(msg *Message) { t.releaseBlobs([]*Message{msg}) }
+	t.deadLetters.onDrop = t.releaseBlobs
+	return t, nil
 }
 
-// adds msg to the topic
+// Partitions returns the topic's partition count
+func (t *Topic) Partitions() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.router.n
+}
+
+// adds msg to the topic, fanning a copy out to every named subscription
 func (t *Topic) Publish(msg *Message) error{
+	msg.Partition = t.route(msg.Key)
+	return commit([]*batchItem{{topic: t, msg: msg}}, nil)
+}
+
+// route picks the partition of a message with the given key
+func (t *Topic) route(key string) int {
 	t.mu.RLock()
-	if t.closed{
-		t.mu.RUnlock()
-		return fmt.Errorf("topic %s is closed", t.Name)
-	}
-	t.mu.RUnlock()
+	defer t.mu.RUnlock()
+	return t.router.route(key)
+}
 
-	return t.queue.Push(msg)
+// fanout returns whether msg goes to the default queue and the named
+// subscriptions whose filter it matches. The default queue behaves like a
+// subscription created on first use: it gets every message once it has
+// been subscribed to, and until then only while the topic has no named
+// subscriptions, so a topic consumed through groups alone doesn't fill it
+// up. Caller holds t.mu
+func (t *Topic) fanout(msg *Message) (bool, []*Subscription) {
+	subs := make([]*Subscription, 0, len(t.subs))
+	load := sync.OnceValues(func() ([]byte, error) { return plainPayload(msg, t.blobStore()) })
+	for _, sub := range t.subs {
+		if sub.Filter().match(msg, load) {
+			subs = append(subs, sub)
+		}
+	}
+	return t.queueUsed || len(t.subs) == 0, subs
 }
 
 // subscribe return the internal q for consuming
 func (t *Topic) Subscribe() *Queue{
+	return t.useQueue()
+}
+
+// useQueue marks the default queue as subscribed and returns it, the first
+// use is journaled so recovery fans messages out the same way
+func (t *Topic) useQueue() *Queue {
+	t.mu.Lock()
+	first := !t.queueUsed
+	t.queueUsed = true
+	t.mu.Unlock()
+
+	if first {
+		t.journalSubscription(OpSubscribe, "", nil)
+	}
 	return t.queue
 }
 
+// CreateSubscription adds a named subscription that receives every message
+// published from now on
+func (t *Topic) CreateSubscription(name string) (*Subscription, error) {
+	return t.createSubscription(name, nil, false)
+}
+
+// createSubscription is CreateSubscription with filter f set from the
+// start, an ephemeral subscription is kept out of the WAL
+func (t *Topic) createSubscription(name string, f *Filter, ephemeral bool) (*Subscription, error) {
+	if name == "" {
+		return nil, fmt.Errorf("subscription name cannot be empty")
+	}
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("topic %s is closed", t.Name)
+	}
+	if _, exists := t.subs[name]; exists {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("subscription %s already exists on topic %s", name, t.Name)
+	}
+	sub := t.addSubscription(name)
+	sub.filter.Store(f)
+	sub.ephemeral = ephemeral
+	t.mu.Unlock()
+
+	if !ephemeral {
+		t.journalSubscription(OpSubscribe, name, f)
+	}
+	return sub, nil
+}
+
+// SetFilter sets the filter of a named subscription, only messages matching
+// it are delivered to the subscription from now on, nil removes the filter
+func (t *Topic) SetFilter(name string, f *Filter) error {
+	sub, ok := t.Subscription(name)
+	if !ok {
+		return fmt.Errorf("subscription %s not found on topic %s", name, t.Name)
+	}
+	sub.filter.Store(f)
+	if !sub.ephemeral {
+		t.journalSubscription(OpSubscribe, name, f)
+	}
+	return nil
+}
+
+// Subscription returns a named subscription
+func (t *Topic) Subscription(name string) (*Subscription, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	sub, ok := t.subs[name]
+	return sub, ok
+}
+
+// DeleteSubscription removes a named subscription and drops its backlog
+func (t *Topic) DeleteSubscription(name string) error {
+	t.mu.Lock()
+	sub, exists := t.subs[name]
+	if !exists {
+		t.mu.Unlock()
+		return fmt.Errorf("subscription %s not found on topic %s", name, t.Name)
+	}
+	delete(t.subs, name)
+	t.mu.Unlock()
+
+	t.releaseBlobs(sub.queue.RemoveIf(func(*Message) bool { return true }))
+	sub.queue.Close()
+	if !sub.ephemeral {
+		t.journalSubscription(OpUnsubscribe, name, nil)
+	}
+	return nil
+}
+
+// Subscriptions returns the names of all named subscriptions
+func (t *Topic) Subscriptions() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	names := make([]string, 0, len(t.subs))
+	for name := range t.subs {
+		names = append(names, name)
+	}
+	return names
+}
+
+// subscriptionQueue returns the queue of a named subscription, creating the
+// subscription with filter f if needed, or the default queue for "". A
+// filter only applies to a new subscription: joining an existing one with a
+// different filter fails rather than changing it for every member
+func (t *Topic) subscriptionQueue(name string, f *Filter, ephemeral bool) (*Queue, error) {
+	if name == "" {
+		return t.useQueue(), nil
+	}
+
+	sub, ok := t.Subscription(name)
+	if !ok {
+		created, err := t.createSubscription(name, f, ephemeral)
+		if err == nil {
+			return created.queue, nil
+		}
+		// Lost a race with a concurrent create
+		if sub, ok = t.Subscription(name); !ok {
+			return nil, err
+		}
+	}
+
+	if current := sub.Filter(); f != nil && (current == nil || current.String() != f.String()) {
+		return nil, fmt.Errorf("group %s on topic %s has a different filter, change it with SetFilter", name, t.Name)
+	}
+	return sub.queue, nil
+}
+
+// addSubscription wires up a new subscription, caller holds t.mu
+func (t *Topic) addSubscription(name string) *Subscription {
+	sub := newSubscription(t.Name, name)
+	t.wireQueue(sub.queue, name)
+	sub.queue.configure(t.config)
+	t.subs[name] = sub
+	return sub
+}
+
+// wireQueue journals acks and drops on a queue delivering subscription sub
+func (t *Topic) wireQueue(q *Queue, sub string) {
+	q.onRelease = func(msg *Message) {
+		t.record(OpAck, sub, []*Message{msg})
+	}
+	q.onDrop = func(msgs []*Message) {
+		t.record(OpRemove, sub, msgs)
+	}
+}
+
+// SetDedupWindow bounds how long and how many idempotency keys are remembered,
+// a zero ttl or maxKeys disables that bound
+func (t *Topic) SetDedupWindow(ttl time.Duration, maxKeys int) {
+	t.dedup.resize(ttl, maxKeys)
+
+	if t.journal == nil {
+		return
+	}
+	entry := &WALEntry{Operation: OpDedupWindow, Topic: t.Name, DedupTTL: ttl, DedupMaxKeys: maxKeys}
+	if err := t.journal(entry); err != nil {
+		log.Printf("[Topic %s] Failed to journal dedup window: %v", t.Name, err)
+	}
+}
+
+// DedupWindow returns how long and how many idempotency keys are remembered
+func (t *Topic) DedupWindow() (time.Duration, int) {
+	return t.dedup.bounds()
+}
+
+// Peek returns the next message of the default queue without consuming it,
+// a named subscription's queue is peeked through Subscription.Queue
+func (t *Topic) Peek() (*Message, bool) {
+	return t.queue.Peek()
+}
+
+// Browse lists the default queue's messages without consuming them, see Peek
+func (t *Topic) Browse(offset, limit int) []*Message {
+	return t.queue.Browse(offset, limit)
+}
+
+// browseQueue returns the queue Peek and Browse read for a subscription,
+// "" is the default queue
+func (t *Topic) browseQueue(sub string) (*Queue, error) {
+	if sub == "" {
+		return t.queue, nil
+	}
+	s, ok := t.Subscription(sub)
+	if !ok {
+		return nil, fmt.Errorf("subscription %s not found on topic %s", sub, t.Name)
+	}
+	return s.queue, nil
+}
+
+// Receive hides the next message for the visibility timeout and returns it
+// with a receipt handle for Delete or ChangeVisibility
+func (t *Topic) Receive(visibility time.Duration) (*Message, string, bool) {
+	return t.receive(visibility, false)
+}
+
+// receive is Receive, with raw leaving a compressed payload as published
+func (t *Topic) receive(visibility time.Duration, raw bool) (*Message, string, bool) {
+	queue := t.useQueue()
+	msg, receipt, ok := queue.Receive(visibility)
+	if !ok {
+		return nil, "", false
+	}
+	if t.tracer != nil {
+		queue.traceLease(receipt, traceDelivery(t.tracer(), msg, nil))
+	}
+	out, err := t.hydrated(msg)
+	if err == nil && !raw {
+		out, err = out.decompressed()
+	}
+	if err != nil {
+		log.Printf("[Topic %s] %v", t.Name, err)
+	}
+	return out, receipt, true
+}
+
+// Delete removes a received message using its receipt handle
+func (t *Topic) Delete(receipt string) error {
+	return t.queue.DeleteMessage(receipt)
+}
+
+// ChangeVisibility extends or shortens how long a received message stays hidden
+func (t *Topic) ChangeVisibility(receipt string, timeout time.Duration) error {
+	return t.queue.ChangeVisibility(receipt, timeout)
+}
+
+// Purge drops every queued message from the default queue and every
+// subscription and returns how many copies were removed
+func (t *Topic) Purge() int {
+	return t.RemoveIf(func(*Message) bool { return true })
+}
+
+// RemoveByID drops the queued message with the given ID everywhere, returning
+// how many queues held it, 0 if none
+func (t *Topic) RemoveByID(id string) int {
+	return t.RemoveIf(func(msg *Message) bool { return msg.ID == id })
+}
+
+// RemoveIf drops every queued message matching filter from the default
+// queue and every subscription and returns how many copies were removed
+func (t *Topic) RemoveIf(filter MessageFilter) int {
+	t.mu.RLock()
+	subs := make([]*Subscription, 0, len(t.subs))
+	for _, sub := range t.subs {
+		subs = append(subs, sub)
+	}
+	t.mu.RUnlock()
+
+	removed := t.queue.RemoveIf(filter)
+	t.record(OpRemove, "", removed)
+	n := len(removed)
+	for _, sub := range subs {
+		removed := sub.queue.RemoveIf(filter)
+		t.record(OpRemove, sub.Name, removed)
+		n += len(removed)
+	}
+	return n
+}
+
+// record journals an operation on msgs delivered through subscription sub
+// ("" for the default queue), a no-op for standalone topics
+func (t *Topic) record(op, sub string, msgs []*Message) {
+	t.releaseBlobs(msgs) // acks and removals both finish a delivery
+
+	if t.journal == nil || len(msgs) == 0 || !t.persistent.Load() {
+		return
+	}
+
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	entry := &WALEntry{Operation: op, Topic: t.Name, MessageIDs: ids, Subscription: sub}
+	if err := t.journal(entry); err != nil {
+		log.Printf("[Topic %s] Failed to journal %s of %d messages: %v", t.Name, op, len(ids), err)
+	}
+}
+
+// journalSubscription records a subscription being created, changed or
+// deleted, "" being the first use of the default queue
+func (t *Topic) journalSubscription(op, name string, f *Filter) {
+	if t.journal == nil {
+		return
+	}
+	entry := &WALEntry{Operation: op, Topic: t.Name, Subscription: name}
+	if f != nil {
+		entry.Filter = f.String()
+	}
+	if err := t.journal(entry); err != nil {
+		log.Printf("[Topic %s] Failed to journal %s of %s: %v", t.Name, op, name, err)
+	}
+}
+
 func (t *Topic) Len() int{
 	return  t.queue.Len()
 }
@@ -47,4 +415,8 @@ func (t *Topic) Close(){
 
 	t.closed = true
 	t.queue.Close()
+	t.deadLetters.Close()
+	for _, sub := range t.subs {
+		sub.queue.Close()
+	}
 }
\ No newline at end of file
@@ -70,4 +70,89 @@ func TestTopicClose(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error publishing to closed topic")
 	}
-}
\ No newline at end of file
+}
+func TestTopicBrowse(t *testing.T) {
+	topic := NewTopic("events")
+	for i := 0; i < 5; i++ {
+		topic.Publish(NewMessage("events", []byte(fmt.Sprintf("event-%d", i))))
+	}
+
+	head, ok := topic.Peek()
+	if !ok || string(head.Payload) != "event-0" {
+		t.Error("Peek should return the head message")
+	}
+
+	page := topic.Browse(1, 2)
+	if len(page) != 2 || string(page[0].Payload) != "event-1" || string(page[1].Payload) != "event-2" {
+		t.Errorf("Unexpected browse page: %v", page)
+	}
+	if len(topic.Browse(10, 2)) != 0 {
+		t.Error("Browse past the end should be empty")
+	}
+
+	if topic.Len() != 5 {
+		t.Errorf("Browsing should not consume, got length %d", topic.Len())
+	}
+}
+
+func TestTopicRemoval(t *testing.T) {
+	topic := NewTopic("events")
+	var msgs []*Message
+	for i := 0; i < 5; i++ {
+		msg := NewMessage("events", []byte(fmt.Sprintf("event-%d", i)))
+		if i%2 == 0 {
+			msg.GroupID = "even"
+		}
+		msgs = append(msgs, msg)
+		topic.Publish(msg)
+	}
+
+	if n := topic.RemoveByID(msgs[1].ID); n != 1 {
+		t.Errorf("Expected 1 removed by ID, got %d", n)
+	}
+	if n := topic.RemoveByID("missing"); n != 0 {
+		t.Errorf("Expected 0 removed for unknown ID, got %d", n)
+	}
+	if n := topic.RemoveIf(InGroup("even")); n != 3 {
+		t.Errorf("Expected 3 removed by group, got %d", n)
+	}
+	if n := topic.Purge(); n != 1 {
+		t.Errorf("Expected purge to remove 1, got %d", n)
+	}
+	if topic.Len() != 0 {
+		t.Errorf("Expected empty topic, got %d", topic.Len())
+	}
+}
+
+func TestTopicRemovalReachesSubscriptions(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+
+	mq.Subscribe("orders", WithGroup("billing"))
+	var ids []string
+	for i := 0; i < 3; i++ {
+		msg, _ := mq.Publish("orders", []byte(fmt.Sprintf("order-%d", i)))
+		ids = append(ids, msg.ID)
+	}
+	topic, _ := mq.GetTopic("orders")
+	billing, _ := topic.Subscription("billing")
+
+	if n := topic.RemoveByID(ids[0]); n != 1 || billing.Len() != 2 {
+		t.Errorf("Expected the message removed from the group, got %d removed and %d left", n, billing.Len())
+	}
+	if n := topic.Purge(); n != 2 || billing.Len() != 0 {
+		t.Errorf("Expected the purge to empty the group, got %d removed and %d left", n, billing.Len())
+	}
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	mq2.AttachWAL(wal2)
+	topic2, _ := mq2.GetTopic("orders")
+	if billing2, ok := topic2.Subscription("billing"); !ok || billing2.Len() != 0 {
+		t.Error("Expected the removals to survive a restart")
+	}
+}
@@ -0,0 +1,175 @@
+package lpacamq
+
+import (
+	"fmt"
+	"time"
+)
+
+// OverflowPolicy decides what happens when a queue reaches MaxDepth
+type OverflowPolicy string
+
+const (
+	OverflowReject     OverflowPolicy = "reject"      // Publish fails
+	OverflowDropOldest OverflowPolicy = "drop-oldest" // the head of the queue is discarded
+	OverflowDropNewest OverflowPolicy = "drop-newest" // the new message is discarded
+)
+
+// TopicConfig holds the per-topic settings, zero values mean unlimited/off
+type TopicConfig struct {
+	MaxDepth       int            // max queued messages per subscription, 0 = unlimited
+	OverflowPolicy OverflowPolicy // applied when MaxDepth is reached
+	TTL            time.Duration  // stamped on each message at publish, expired messages are never delivered
+	MaxRetries     int            // redeliveries after a handler error before dead-lettering
+	RetryBackoff   time.Duration  // delay before redelivery, multiplied by the retry count
+	Retention      time.Duration  // max age of anything queued or dead-lettered, evaluated against the current setting
+	Persistent     bool           // log messages to the WAL
+	Partitions     int            // partition count, can only grow
+}
+
+// DefaultTopicConfig returns the settings used by CreateTopic and auto-created topics
+func DefaultTopicConfig() TopicConfig {
+	return TopicConfig{
+		OverflowPolicy: OverflowReject,
+		Persistent:     true,
+		Partitions:     1,
+	}
+}
+
+func (c *TopicConfig) validate() error {
+	if c.OverflowPolicy == "" {
+		c.OverflowPolicy = OverflowReject
+	}
+	if c.Partitions == 0 {
+		c.Partitions = 1
+	}
+
+	switch c.OverflowPolicy {
+	case OverflowReject, OverflowDropOldest, OverflowDropNewest:
+	default:
+		return fmt.Errorf("unknown overflow policy %q", c.OverflowPolicy)
+	}
+	if c.MaxDepth < 0 || c.MaxRetries < 0 || c.Partitions < 0 {
+		return fmt.Errorf("max depth, max retries and partitions cannot be negative")
+	}
+	if c.TTL < 0 || c.RetryBackoff < 0 || c.Retention < 0 {
+		return fmt.Errorf("durations cannot be negative")
+	}
+	return nil
+}
+
+// Config returns the topic's current settings
+func (t *Topic) Config() TopicConfig {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.config
+}
+
+// SetConfig replaces the topic's settings at runtime. Depth, overflow and
+// retention apply to everything queued right away, TTL only to messages
+// published from now on
+func (t *Topic) SetConfig(cfg TopicConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if cfg.Partitions < t.router.n {
+		t.mu.Unlock()
+		return fmt.Errorf("topic %s has %d partitions, the count can only grow", t.Name, t.router.n)
+	}
+	if cfg.Partitions != t.router.n {
+		t.router = newPartitionRouter(cfg.Partitions)
+	}
+	t.config = cfg
+	t.persistent.Store(cfg.Persistent)
+	queues := []*Queue{t.queue}
+	for _, sub := range t.subs {
+		queues = append(queues, sub.queue)
+	}
+	t.mu.Unlock()
+
+	for _, q := range queues {
+		q.configure(cfg)
+	}
+	t.deadLetters.configure(deadLetterConfig(cfg))
+	return nil
+}
+
+// deadLetterConfig keeps only retention for the dead-letter queue, it is
+// never bounded or partitioned
+func deadLetterConfig(cfg TopicConfig) TopicConfig {
+	return TopicConfig{OverflowPolicy: OverflowReject, Retention: cfg.Retention, Partitions: 1}
+}
+
+// DeadLetters returns the queue of messages that exhausted MaxRetries
+func (t *Topic) DeadLetters() *Queue {
+	return t.deadLetters
+}
+
+// configure applies topic settings to the q
+func (q *Queue) configure(cfg TopicConfig) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.maxDepth = cfg.MaxDepth
+	q.overflow = cfg.OverflowPolicy
+	q.retention = cfg.Retention
+	q.partitioned = cfg.Partitions > 1
+
+	q.dropExpired()
+	if q.maxDepth > 0 && len(q.messages) > q.maxDepth && q.overflow == OverflowDropOldest {
+		q.drop(len(q.messages) - q.maxDepth)
+	}
+	q.cond.Broadcast()
+}
+
+// full reports whether pushing would hit MaxDepth under the reject policy
+func (q *Queue) full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.maxDepth > 0 && q.overflow == OverflowReject && len(q.messages) >= q.maxDepth
+}
+
+// room reports whether n more messages fit under the reject policy, caller
+// holds q.mu
+func (q *Queue) room(n int) bool {
+	return q.maxDepth <= 0 || q.overflow != OverflowReject || len(q.messages)+n <= q.maxDepth
+}
+
+// dropExpired drops messages past their TTL or the retention window,
+// caller holds q.mu
+func (q *Queue) dropExpired() {
+	if q.retention <= 0 && !q.hasTTL {
+		return
+	}
+	now := time.Now()
+
+	var dropped []*Message
+	kept := q.messages[:0]
+	for _, msg := range q.messages {
+		if msg.expired(now) || (q.retention > 0 && now.Sub(msg.Timestamp) > q.retention) {
+			dropped = append(dropped, msg)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	if len(dropped) == 0 {
+		return
+	}
+	for i := len(kept); i < len(q.messages); i++ {
+		q.messages[i] = nil
+	}
+	q.messages = kept
+	if q.onDrop != nil {
+		q.onDrop(dropped)
+	}
+}
+
+// drop discards the n oldest messages, caller holds q.mu
+func (q *Queue) drop(n int) {
+	dropped := append([]*Message(nil), q.messages[:n]...)
+	q.messages = q.messages[n:]
+	if q.onDrop != nil {
+		q.onDrop(dropped)
+	}
+}
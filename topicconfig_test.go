@@ -0,0 +1,201 @@
+package lpacamq
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTopicConfigOverflow(t *testing.T) {
+	policies := []struct {
+		policy OverflowPolicy
+		first  string // head of the queue after 3 publishes into depth 2
+		err    bool
+	}{
+		{OverflowReject, "m0", true},
+		{OverflowDropOldest, "m1", false},
+		{OverflowDropNewest, "m0", false},
+	}
+
+	for _, tc := range policies {
+		mq := New()
+		cfg := DefaultTopicConfig()
+		cfg.MaxDepth = 2
+		cfg.OverflowPolicy = tc.policy
+		if err := mq.CreateTopicWithConfig("orders", cfg); err != nil {
+			t.Fatalf("CreateTopicWithConfig failed: %v", err)
+		}
+
+		var lastErr error
+		for i := 0; i < 3; i++ {
+			_, lastErr = mq.Publish("orders", []byte(fmt.Sprintf("m%d", i)))
+		}
+		if tc.err != errors.Is(lastErr, ErrQueueFull) {
+			t.Errorf("%s: unexpected publish error %v", tc.policy, lastErr)
+		}
+
+		topic, _ := mq.GetTopic("orders")
+		if topic.Len() != 2 {
+			t.Errorf("%s: expected depth 2, got %d", tc.policy, topic.Len())
+		}
+		if head, _ := topic.Peek(); string(head.Payload) != tc.first {
+			t.Errorf("%s: expected head %s, got %s", tc.policy, tc.first, head.Payload)
+		}
+	}
+}
+
+func TestTopicConfigTTLAndRetention(t *testing.T) {
+	mq := New()
+	cfg := DefaultTopicConfig()
+	cfg.TTL = 50 * time.Millisecond
+	mq.CreateTopicWithConfig("events", cfg)
+
+	msg, _ := mq.Publish("events", []byte("short-lived"))
+	if msg.ExpiresAt.IsZero() {
+		t.Fatal("Expected the TTL stamped on the message")
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	topic, _ := mq.GetTopic("events")
+	if topic.Len() != 0 {
+		t.Errorf("Expected the expired message dropped, got depth %d", topic.Len())
+	}
+
+	// Retention applies to what is already queued once it is set
+	cfg.TTL = 0
+	mq.UpdateTopicConfig("events", cfg)
+	mq.Publish("events", []byte("old"))
+	time.Sleep(30 * time.Millisecond)
+
+	cfg.Retention = 10 * time.Millisecond
+	if err := mq.UpdateTopicConfig("events", cfg); err != nil {
+		t.Fatalf("UpdateTopicConfig failed: %v", err)
+	}
+	if topic.Len() != 0 {
+		t.Errorf("Expected retention to drop the old message, got depth %d", topic.Len())
+	}
+}
+
+func TestTopicConfigRetriesAndDeadLetters(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	cfg := DefaultTopicConfig()
+	cfg.MaxRetries = 2
+	cfg.RetryBackoff = 5 * time.Millisecond
+	mq.CreateTopicWithConfig("jobs", cfg)
+
+	var attempts int32
+	mq.SubscribeWithHandler("jobs", func(msg *Message) error {
+		atomic.AddInt32(&attempts, 1)
+		return fmt.Errorf("always fails")
+	})
+	mq.Publish("jobs", []byte("job"))
+
+	time.Sleep(200 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("Expected 1 attempt and 2 retries, got %d", n)
+	}
+	topic, _ := mq.GetTopic("jobs")
+	dead, ok := topic.DeadLetters().PopNonBlocking()
+	if !ok || dead.RetryCount != 2 {
+		t.Errorf("Expected the message dead-lettered after 2 retries, got %v", dead)
+	}
+}
+
+func TestTopicConfigUpdate(t *testing.T) {
+	mq := New()
+	mq.CreatePartitionedTopic("orders", 2)
+
+	topic, err := mq.GetTopic("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := topic.Config()
+	update.Partitions = 4
+	if err := mq.UpdateTopicConfig("orders", update); err != nil {
+		t.Fatalf("UpdateTopicConfig failed: %v", err)
+	}
+	if topic.Partitions() != 4 {
+		t.Errorf("Expected 4 partitions, got %d", topic.Partitions())
+	}
+
+	update.Partitions = 3
+	if err := mq.UpdateTopicConfig("orders", update); err == nil {
+		t.Error("Expected shrinking partitions to fail")
+	}
+	update.OverflowPolicy = "bogus"
+	if err := mq.UpdateTopicConfig("orders", update); err == nil {
+		t.Error("Expected an unknown overflow policy to fail")
+	}
+}
+
+func TestTopicConfigNonPersistent(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+
+	mq := New()
+	mq.AttachWAL(wal)
+	cfg := DefaultTopicConfig()
+	cfg.Persistent = false
+	cfg.MaxDepth = 5
+	mq.CreateTopicWithConfig("metrics", cfg)
+	mq.Publish("metrics", []byte("cpu=80"))
+	wal.Close()
+
+	wal, _ = NewWAL(dir)
+	defer wal.Close()
+	restarted := New()
+	restarted.AttachWAL(wal)
+
+	topic, err := restarted.GetTopic("metrics")
+	if err != nil {
+		t.Fatalf("Expected the topic restored: %v", err)
+	}
+	if topic.Len() != 0 {
+		t.Errorf("Expected no messages restored, got %d", topic.Len())
+	}
+	if got := topic.Config(); got.Persistent || got.MaxDepth != 5 {
+		t.Errorf("Expected the config restored, got %+v", got)
+	}
+}
+
+func TestRejectedPublishNotJournaled(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+
+	cfg := DefaultTopicConfig()
+	cfg.MaxDepth = 1
+	mq.CreateTopicWithConfig("orders", cfg)
+	mq.Publish("orders", []byte("a"))
+	if _, err := mq.Publish("orders", []byte("b")); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+
+	// A lower depth than the backlog drops what doesn't fit on restore
+	mq.Publish("invoices", []byte("1"))
+	mq.Publish("invoices", []byte("2"))
+	cfg.MaxDepth = 1
+	mq.UpdateTopicConfig("invoices", cfg)
+	wal.Close()
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	if err := mq2.AttachWAL(wal2); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+	orders, _ := mq2.GetTopic("orders")
+	if orders.Len() != 1 {
+		t.Errorf("Expected only the accepted message back, got %d", orders.Len())
+	}
+	invoices, _ := mq2.GetTopic("invoices")
+	if invoices.Len() != 1 {
+		t.Errorf("Expected the backlog cut to the new depth, got %d", invoices.Len())
+	}
+}
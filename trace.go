@@ -0,0 +1,298 @@
+package lpacamq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader carries the W3C trace context of a message
+const TraceparentHeader = "traceparent"
+
+// TraceContext is a W3C trace context, see https://www.w3.org/TR/trace-context/
+type TraceContext struct {
+	TraceID string // 32 hex digits
+	SpanID  string // 16 hex digits
+	Flags   byte   // 0x01 is sampled
+}
+
+// ParseTraceparent parses a traceparent header value
+func ParseTraceparent(s string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return TraceContext{}, fmt.Errorf("traceparent %q: expected version 00 with 4 fields", s)
+	}
+	tc := TraceContext{TraceID: parts[1], SpanID: parts[2]}
+	if !isHex(tc.TraceID, 32) || !isHex(tc.SpanID, 16) || !isHex(parts[3], 2) {
+		return TraceContext{}, fmt.Errorf("traceparent %q: malformed ids", s)
+	}
+	if strings.Trim(tc.TraceID, "0") == "" || strings.Trim(tc.SpanID, "0") == "" {
+		return TraceContext{}, fmt.Errorf("traceparent %q: all-zero id", s)
+	}
+	flags, _ := strconv.ParseUint(parts[3], 16, 8)
+	tc.Flags = byte(flags)
+	return tc, nil
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the context as a traceparent header value
+func (tc TraceContext) String() string {
+	return fmt.Sprintf("00-%s-%s-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+// Valid reports whether the context has a trace and span ID
+func (tc TraceContext) Valid() bool {
+	return tc.TraceID != "" && tc.SpanID != ""
+}
+
+// child returns a new span in the same trace, or the root of a new sampled
+// trace if tc is not valid
+func (tc TraceContext) child() TraceContext {
+	if !tc.Valid() {
+		return TraceContext{TraceID: GenerateTraceID(), SpanID: GenerateSpanID(), Flags: 0x01}
+	}
+	return TraceContext{TraceID: tc.TraceID, SpanID: GenerateSpanID(), Flags: tc.Flags}
+}
+
+type traceKey struct{}
+
+// ContextWithTrace returns a copy of ctx carrying tc
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+// TraceFromContext returns the trace context carried by ctx
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(TraceContext)
+	return tc, ok
+}
+
+// Span is a timed operation reported to a Tracer
+type Span interface {
+	End(err error)
+}
+
+// Tracer receives the broker's spans: "publish", "enqueue-wait" (from publish
+// to delivery), "deliver" (the handler call, for received messages until they
+// are deleted or become visible again) and "ack" or "nack" (how the delivery
+// was finished). The broker assigns span IDs itself, so a tracer only has to
+// record or export them
+type Tracer interface {
+	StartSpan(name string, span, parent TraceContext, start time.Time, attrs map[string]string) Span
+}
+
+type noopTracer struct{}
+type noopSpan struct{}
+
+func (noopTracer) StartSpan(string, TraceContext, TraceContext, time.Time, map[string]string) Span {
+	return noopSpan{}
+}
+
+func (noopSpan) End(error) {}
+
+// SetTracer installs the tracer for the broker and its namespaces, nil
+// turns span reporting off. Trace contexts are propagated either way
+func (mq *LpacaMQ) SetTracer(t Tracer) {
+	mq.mu.Lock()
+	mq.tracer = t
+	mq.mu.Unlock()
+}
+
+// currentTracer returns the root's tracer, never nil
+func (mq *LpacaMQ) currentTracer() Tracer {
+	if mq.parent != nil {
+		return mq.parent.currentTracer()
+	}
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+	if mq.tracer == nil {
+		return noopTracer{}
+	}
+	return mq.tracer
+}
+
+// tracePublish continues the trace in msg's traceparent header, or starts
+// one, and stamps the publish span's context on the message so consumer
+// spans become its children
+func (mq *LpacaMQ) tracePublish(msg *Message) Span {
+	parent, _ := ParseTraceparent(msg.Headers[TraceparentHeader])
+	span := parent.child()
+
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string, 1)
+	}
+	msg.Headers[TraceparentHeader] = span.String()
+
+	return mq.currentTracer().StartSpan("publish", span, parent, msg.Timestamp, messageAttrs(msg))
+}
+
+// TraceContext returns the trace context stamped on the message at publish
+func (m *Message) TraceContext() (TraceContext, bool) {
+	tc, err := ParseTraceparent(m.Headers[TraceparentHeader])
+	return tc, err == nil
+}
+
+// Context returns the context a handler runs in: it carries the trace context
+// of the delivery span, or of the publish span outside a handler
+func (m *Message) Context() context.Context {
+	m.mu.RLock()
+	ctx := m.ctx
+	m.mu.RUnlock()
+	if ctx != nil {
+		return ctx
+	}
+
+	ctx = context.Background()
+	if tc, ok := m.TraceContext(); ok {
+		ctx = ContextWithTrace(ctx, tc)
+	}
+	return ctx
+}
+
+func (m *Message) setContext(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.mu.Unlock()
+}
+
+func messageAttrs(msg *Message) map[string]string {
+	return map[string]string{"topic": msg.Topic, "message_id": msg.ID}
+}
+
+// errReturned ends the deliver span of a received message that went back to
+// the queue instead of being deleted
+var errReturned = errors.New("returned to the queue before it was deleted")
+
+// deliveryTrace reports the spans of one delivery of a message, a nil
+// deliveryTrace reports nothing
+type deliveryTrace struct {
+	tracer  Tracer
+	span    TraceContext // the deliver span
+	deliver Span
+	attrs   map[string]string
+}
+
+type deliveryKey struct{}
+
+// traceDelivery reports the enqueue-wait span of msg and starts its deliver
+// span, attrs are added to the message's own
+func traceDelivery(tracer Tracer, msg *Message, attrs map[string]string) *deliveryTrace {
+	parent, _ := msg.TraceContext()
+	all := messageAttrs(msg)
+	for k, v := range attrs {
+		all[k] = v
+	}
+
+	tracer.StartSpan("enqueue-wait", parent.child(), parent, msg.Timestamp, all).End(nil)
+
+	span := parent.child()
+	return &deliveryTrace{
+		tracer:  tracer,
+		span:    span,
+		deliver: tracer.StartSpan("deliver", span, parent, time.Now(), all),
+		attrs:   all,
+	}
+}
+
+// context returns the context a handler runs in, it carries the deliver span
+func (d *deliveryTrace) context() context.Context {
+	return context.WithValue(ContextWithTrace(context.Background(), d.span), deliveryKey{}, d)
+}
+
+// deliveryFromContext returns the delivery a handler context belongs to
+func deliveryFromContext(ctx context.Context) *deliveryTrace {
+	d, _ := ctx.Value(deliveryKey{}).(*deliveryTrace)
+	return d
+}
+
+// delivered ends the deliver span
+func (d *deliveryTrace) delivered(err error) {
+	if d != nil {
+		d.deliver.End(err)
+	}
+}
+
+// settle starts the "ack" or "nack" span finishing the delivery
+func (d *deliveryTrace) settle(name string) Span {
+	if d == nil {
+		return noopSpan{}
+	}
+	return d.tracer.StartSpan(name, d.span.child(), d.span, time.Now(), d.attrs)
+}
+
+// returned finishes a delivery whose message went back to the queue
+func (d *deliveryTrace) returned() {
+	d.delivered(errReturned)
+	d.settle("nack").End(nil)
+}
+
+// ContextHandler processes messages with the delivery's context
+type ContextHandler func(ctx context.Context, msg *Message) error
+
+// SubscribeWithContext is SubscribeWithHandler for handlers that take the
+// delivery context, which carries the message's trace context
+func (mq *LpacaMQ) SubscribeWithContext(topicName string, handler ContextHandler, opts ...SubscribeOption) (*Consumer, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("handler cannot be nil")
+	}
+	return mq.SubscribeWithHandler(topicName, func(msg *Message) error {
+		return handler(msg.Context(), msg)
+	}, opts...)
+}
+
+// SpanRecord is a finished span kept by a RecordingTracer
+type SpanRecord struct {
+	Name   string
+	Span   TraceContext
+	Parent TraceContext
+	Start  time.Time
+	End    time.Time
+	Attrs  map[string]string
+	Err    error
+}
+
+// RecordingTracer keeps finished spans in memory, for tests and debugging
+type RecordingTracer struct {
+	spans []SpanRecord
+	mu    sync.Mutex
+}
+
+// StartSpan implements Tracer
+func (r *RecordingTracer) StartSpan(name string, span, parent TraceContext, start time.Time, attrs map[string]string) Span {
+	return &recordingSpan{tracer: r, rec: SpanRecord{Name: name, Span: span, Parent: parent, Start: start, Attrs: attrs}}
+}
+
+// Spans returns the finished spans in the order they ended
+func (r *RecordingTracer) Spans() []SpanRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SpanRecord(nil), r.spans...)
+}
+
+type recordingSpan struct {
+	tracer *RecordingTracer
+	rec    SpanRecord
+}
+
+func (s *recordingSpan) End(err error) {
+	s.rec.End = time.Now()
+	s.rec.Err = err
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s.rec)
+	s.tracer.mu.Unlock()
+}
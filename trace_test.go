@@ -0,0 +1,182 @@
+package lpacamq
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tc, err := ParseTraceparent(tp)
+	if err != nil {
+		t.Fatalf("ParseTraceparent failed: %v", err)
+	}
+	if tc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || tc.SpanID != "00f067aa0ba902b7" || tc.Flags != 1 {
+		t.Errorf("Unexpected trace context %+v", tc)
+	}
+	if tc.String() != tp {
+		t.Errorf("Expected %s, got %s", tp, tc.String())
+	}
+
+	for _, bad := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestPublishMintsTraceparent(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	msg, err := mq.Publish("traced", []byte("x"))
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if _, ok := msg.TraceContext(); !ok {
+		t.Fatalf("Expected a minted traceparent, got %q", msg.Headers[TraceparentHeader])
+	}
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	msg, _ = mq.Publish("traced", []byte("y"), WithHeaders(map[string]string{TraceparentHeader: parent}))
+	tc, _ := msg.TraceContext()
+	if tc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the caller's trace to continue, got %s", tc.TraceID)
+	}
+	if tc.SpanID == "00f067aa0ba902b7" {
+		t.Error("Expected the publish span to get its own span ID")
+	}
+}
+
+func TestTraceSpans(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	tracer := &RecordingTracer{}
+	mq.SetTracer(tracer)
+
+	got := make(chan TraceContext, 1)
+	mq.SubscribeWithContext("traced", func(ctx context.Context, msg *Message) error {
+		tc, _ := TraceFromContext(ctx)
+		got <- tc
+		return nil
+	})
+
+	msg, err := mq.Publish("traced", []byte("x"))
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	published, _ := msg.TraceContext()
+
+	var handled TraceContext
+	select {
+	case handled = <-got:
+	case <-time.After(time.Second):
+		t.Fatal("Handler was not called")
+	}
+	if handled.TraceID != published.TraceID {
+		t.Errorf("Expected the handler in trace %s, got %s", published.TraceID, handled.TraceID)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(tracer.Spans()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	spans := make(map[string]SpanRecord)
+	for _, span := range tracer.Spans() {
+		spans[span.Name] = span
+	}
+	for _, name := range []string{"publish", "enqueue-wait", "deliver", "ack"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("Missing %s span, got %v", name, tracer.Spans())
+		}
+		if span.Span.TraceID != published.TraceID {
+			t.Errorf("Span %s is in trace %s, expected %s", name, span.Span.TraceID, published.TraceID)
+		}
+	}
+	if spans["publish"].Span != published {
+		t.Error("Expected the message to carry the publish span")
+	}
+	if spans["deliver"].Parent != published || spans["deliver"].Span != handled {
+		t.Error("Expected the handler context to be the deliver span, a child of publish")
+	}
+	if spans["ack"].Parent != handled {
+		t.Error("Expected the ack span to be a child of deliver")
+	}
+}
+
+// spanNames returns the names of the finished spans of a message
+func spanNames(tracer *RecordingTracer, id string) []string {
+	var names []string
+	for _, span := range tracer.Spans() {
+		if span.Attrs["message_id"] == id {
+			names = append(names, span.Name)
+		}
+	}
+	return names
+}
+
+func TestTraceReceivedMessages(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	tracer := &RecordingTracer{}
+	mq.SetTracer(tracer)
+	mq.CreateTopic("pulled")
+	topic, _ := mq.GetTopic("pulled")
+
+	deleted, _ := mq.Publish("pulled", []byte("x"))
+	returned, _ := mq.Publish("pulled", []byte("y"))
+
+	_, receipt, _ := topic.Receive(time.Minute)
+	topic.Delete(receipt)
+	if got := strings.Join(spanNames(tracer, deleted.ID), " "); got != "publish enqueue-wait deliver ack" {
+		t.Errorf("Expected a deleted message acked, got %s", got)
+	}
+
+	_, receipt, _ = topic.Receive(time.Minute)
+	topic.ChangeVisibility(receipt, 0)
+	if got := strings.Join(spanNames(tracer, returned.ID), " "); got != "publish enqueue-wait deliver nack" {
+		t.Errorf("Expected a returned message nacked, got %s", got)
+	}
+}
+
+func TestTraceManualAck(t *testing.T) {
+	tracer := &RecordingTracer{}
+	queue := NewQueue()
+	acks := make(chan *AckableMessage, 1)
+	rc := NewReliableConsumer("manual", "orders", func(msg *AckableMessage) error {
+		acks <- msg
+		return nil
+	}, queue, 3)
+	rc.tracer = func() Tracer { return tracer }
+
+	msg := NewMessage("orders", []byte("x"))
+	queue.Push(msg)
+	rc.Start()
+	defer rc.Stop()
+
+	select {
+	case am := <-acks:
+		am.Ack()
+	case <-time.After(time.Second):
+		t.Fatal("Handler was not called")
+	}
+	spans := tracer.Spans()
+	if got := strings.Join(spanNames(tracer, msg.ID), " "); got != "enqueue-wait deliver ack" {
+		t.Fatalf("Expected the manual ack traced, got %s", got)
+	}
+	if spans[2].Parent != spans[1].Span {
+		t.Error("Expected the ack span to be a child of deliver")
+	}
+}
@@ -0,0 +1,124 @@
+package lpacamq
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// ErrTxDone is returned when using a transaction that was already committed
+// or aborted
+var ErrTxDone = errors.New("transaction already committed or aborted")
+
+// Tx groups publishes to any number of topics that become visible together
+// on Commit, or not at all on Abort. Messages are validated, compressed and
+// journaled as they are published, so Commit only has to enqueue them
+type Tx struct {
+	ID string
+
+	mq    *LpacaMQ
+	items []*batchItem
+	done  bool
+	mu    sync.Mutex
+}
+
+// BeginTx starts a transaction. Its markers go to the WAL, and a
+// transaction that was neither committed nor aborted before a crash is
+// rolled back during recovery
+func (mq *LpacaMQ) BeginTx() (*Tx, error) {
+	tx := &Tx{ID: GenerateID(), mq: mq}
+	if err := mq.writeWAL(&WALEntry{Operation: OpBeginTx, TxID: tx.ID}); err != nil {
+		return nil, fmt.Errorf("wal write failed: %w", err)
+	}
+	return tx, nil
+}
+
+// Publish adds a message to the transaction, consumers don't see it until
+// Commit. Validation errors are returned here and leave the transaction
+// open
+func (tx *Tx) Publish(topicName string, payload []byte, opts ...PublishOption) (*Message, error) {
+	if topicName == "" {
+		return nil, fmt.Errorf("topic name cannot be empty")
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return nil, ErrTxDone
+	}
+
+	mq := tx.mq
+	topic, err := mq.getOrCreateTopic(topicName)
+	if err != nil {
+		return nil, err
+	}
+
+	o := applyPublishOptions(opts)
+	msg := newPublishMessage(topicName, payload, o)
+	if orig, dup := reserveKey(topic, msg); dup {
+		return orig, nil
+	}
+	item := &batchItem{topic: topic, msg: msg, span: mq.tracePublish(msg)}
+
+	err = mq.prepareMessage(topic, msg, o.encoding)
+	if err == nil && topic.Config().Persistent {
+		if err = mq.writeWAL(&WALEntry{Operation: OpPublish, Topic: topicName, Message: msg, TxID: tx.ID}); err != nil {
+			err = fmt.Errorf("wal write failed: %w", err)
+		}
+	}
+	if err != nil {
+		mq.abandonBatch([]*batchItem{item}, err)
+		return nil, err
+	}
+
+	tx.items = append(tx.items, item)
+	return msg, nil
+}
+
+// Commit makes every message of the transaction visible at once. If they
+// can't all be enqueued, e.g. because a topic is full, none is and the
+// transaction is aborted
+func (tx *Tx) Commit() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	record := func([]*Message) *WALEntry {
+		return &WALEntry{Operation: OpCommitTx, TxID: tx.ID}
+	}
+	if err := tx.mq.commitBatch(tx.items, record); err != nil {
+		tx.rollback(err)
+		return fmt.Errorf("commit transaction %s: %w", tx.ID, err)
+	}
+	for _, item := range tx.items {
+		item.span.End(nil)
+	}
+	return nil
+}
+
+// Abort discards every message of the transaction
+func (tx *Tx) Abort() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	tx.rollback(fmt.Errorf("transaction %s aborted", tx.ID))
+	return nil
+}
+
+// rollback journals the abort and undoes the preparation of the messages,
+// caller holds tx.mu
+func (tx *Tx) rollback(err error) {
+	if werr := tx.mq.writeWAL(&WALEntry{Operation: OpAbortTx, TxID: tx.ID}); werr != nil {
+		log.Printf("[LpacaMQ] Failed to journal abort of transaction %s: %v", tx.ID, werr)
+	}
+	tx.mq.abandonBatch(tx.items, err)
+	tx.items = nil
+}
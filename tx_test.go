@@ -0,0 +1,134 @@
+package lpacamq
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTxCommit(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	got := make(chan string, 2)
+	for _, topic := range []string{"order.created", "inventory.reserve"} {
+		mq.SubscribeWithHandler(topic, func(msg *Message) error {
+			got <- msg.Topic
+			return nil
+		})
+	}
+
+	tx, err := mq.BeginTx()
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	if _, err := tx.Publish("order.created", []byte("o-1")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if _, err := tx.Publish("inventory.reserve", []byte("sku-42")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	select {
+	case topic := <-got:
+		t.Fatalf("Message on %s delivered before commit", topic)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-got:
+		case <-time.After(time.Second):
+			t.Fatal("Committed message was not delivered")
+		}
+	}
+
+	if _, err := tx.Publish("order.created", []byte("o-2")); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Expected ErrTxDone, got %v", err)
+	}
+	if err := tx.Abort(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Expected ErrTxDone, got %v", err)
+	}
+}
+
+func TestTxAbort(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	tx, _ := mq.BeginTx()
+	tx.Publish("order.created", []byte("o-1"), WithIdempotencyKey("order-1"))
+	if err := tx.Abort(); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+
+	topic, _ := mq.GetTopic("order.created")
+	if topic.Len() != 0 {
+		t.Errorf("Expected nothing enqueued, got %d", topic.Len())
+	}
+	// The aborted publish released its idempotency key
+	msg, _ := mq.Publish("order.created", []byte("o-1"), WithIdempotencyKey("order-1"))
+	if topic.Len() != 1 || msg == nil {
+		t.Errorf("Expected the key to be usable again")
+	}
+}
+
+func TestTxCommitFailureRollsBack(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	cfg := DefaultTopicConfig()
+	cfg.MaxDepth = 1
+	mq.CreateTopicWithConfig("inventory.reserve", cfg)
+	mq.Publish("inventory.reserve", []byte("sku-1"))
+
+	tx, _ := mq.BeginTx()
+	tx.Publish("order.created", []byte("o-1"))
+	tx.Publish("inventory.reserve", []byte("sku-2"))
+	if err := tx.Commit(); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+	orders, _ := mq.GetTopic("order.created")
+	if orders.Len() != 0 {
+		t.Errorf("Expected the order rolled back, got %d", orders.Len())
+	}
+}
+
+func TestTxRecovery(t *testing.T) {
+	dir := t.TempDir()
+	wal, _ := NewWAL(dir)
+	mq := New()
+	mq.AttachWAL(wal)
+
+	committed, _ := mq.BeginTx()
+	committed.Publish("orders", []byte("committed"))
+	committed.Commit()
+
+	aborted, _ := mq.BeginTx()
+	aborted.Publish("orders", []byte("aborted"))
+	aborted.Abort()
+
+	open, _ := mq.BeginTx()
+	open.Publish("orders", []byte("open"))
+	open.Publish("inventory", []byte("open"))
+	wal.Close() // crash with the transaction open
+
+	wal2, _ := NewWAL(dir)
+	defer wal2.Close()
+	mq2 := New()
+	defer mq2.Close()
+	if err := mq2.AttachWAL(wal2); err != nil {
+		t.Fatalf("AttachWAL failed: %v", err)
+	}
+
+	orders, _ := mq2.GetTopic("orders")
+	msg, ok := orders.Peek()
+	if orders.Len() != 1 || !ok || string(msg.Payload) != "committed" {
+		t.Errorf("Expected only the committed message, got depth %d", orders.Len())
+	}
+	if inventory, err := mq2.GetTopic("inventory"); err == nil && inventory.Len() != 0 {
+		t.Errorf("Expected the open transaction rolled back, got %d", inventory.Len())
+	}
+}
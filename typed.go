@@ -0,0 +1,183 @@
+package lpacamq
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// ContentTypeHeader names the codec a typed message was encoded with
+const ContentTypeHeader = "content-type"
+
+// Content types of the built-in codecs
+const (
+	ContentTypeJSON = "application/json"
+	ContentTypeGob  = "application/x-gob"
+)
+
+// Codec turns values into payloads and back for typed publishers and
+// subscribers
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes values with encoding/json
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string                { return ContentTypeJSON }
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// GobCodec encodes values with encoding/gob
+type GobCodec struct{}
+
+func (GobCodec) ContentType() string { return ContentTypeGob }
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var codecs = struct {
+	byType map[string]Codec
+	mu     sync.RWMutex
+}{byType: map[string]Codec{
+	ContentTypeJSON: JSONCodec{},
+	ContentTypeGob:  GobCodec{},
+}}
+
+// RegisterCodec adds or replaces the codec for its content type
+func RegisterCodec(c Codec) {
+	codecs.mu.Lock()
+	codecs.byType[mediaType(c.ContentType())] = c
+	codecs.mu.Unlock()
+}
+
+// LookupCodec returns the codec for a content type, parameters such as
+// charset are ignored
+func LookupCodec(contentType string) (Codec, bool) {
+	codecs.mu.RLock()
+	defer codecs.mu.RUnlock()
+	c, ok := codecs.byType[mediaType(contentType)]
+	return c, ok
+}
+
+func mediaType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// Publisher publishes values of type T to one topic, encoded with its codec
+// and tagged with the codec's content type
+type Publisher[T any] struct {
+	mq    *LpacaMQ
+	topic string
+	codec Codec
+}
+
+// NewPublisher returns a publisher for topic using the codec registered for
+// contentType, empty means JSON
+func NewPublisher[T any](mq *LpacaMQ, topic, contentType string) (*Publisher[T], error) {
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+	codec, ok := LookupCodec(contentType)
+	if !ok {
+		return nil, fmt.Errorf("no codec for content type %q", contentType)
+	}
+	return &Publisher[T]{mq: mq, topic: topic, codec: codec}, nil
+}
+
+// Publish encodes v and publishes it. A trace context in ctx, e.g. from a
+// handler's context, is continued by the message
+func (p *Publisher[T]) Publish(ctx context.Context, v T, opts ...PublishOption) (*Message, error) {
+	payload, err := p.codec.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encode %s: %w", p.codec.ContentType(), err)
+	}
+
+	extra := map[string]string{ContentTypeHeader: p.codec.ContentType()}
+	if tc, ok := TraceFromContext(ctx); ok {
+		extra[TraceparentHeader] = tc.String()
+	}
+	return p.mq.Publish(p.topic, payload, append(opts, addHeaders(extra))...)
+}
+
+// addHeaders sets headers on top of those from earlier options, without
+// changing the caller's map
+func addHeaders(headers map[string]string) PublishOption {
+	return func(o *publishOptions) {
+		merged := copyHeaders(o.headers)
+		if merged == nil {
+			merged = make(map[string]string, len(headers))
+		}
+		for k, v := range headers {
+			merged[k] = v
+		}
+		o.headers = merged
+	}
+}
+
+// TypedHandler processes a decoded value along with its message
+type TypedHandler[T any] func(ctx context.Context, v T, msg *Message) error
+
+// Subscribe consumes topic with a handler for values of type T, decoded by
+// the codec for each message's content type (JSON when it has none). A
+// message that can't be decoded goes straight to the topic's dead-letter
+// queue, without retries
+func Subscribe[T any](mq *LpacaMQ, topic string, handler TypedHandler[T], opts ...SubscribeOption) (*Consumer, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("handler cannot be nil")
+	}
+	return mq.SubscribeWithContext(topic, func(ctx context.Context, msg *Message) error {
+		var v T
+		if err := decodeMessage(msg, &v); err != nil {
+			mq.deadLetterUndecodable(msg, err)
+			return nil
+		}
+		return handler(ctx, v, msg)
+	}, opts...)
+}
+
+func decodeMessage(msg *Message, v any) error {
+	contentType := msg.Headers[ContentTypeHeader]
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+	codec, ok := LookupCodec(contentType)
+	if !ok {
+		return fmt.Errorf("no codec for content type %q", contentType)
+	}
+	if err := codec.Unmarshal(msg.Payload, v); err != nil {
+		return fmt.Errorf("decode %s: %w", codec.ContentType(), err)
+	}
+	return nil
+}
+
+func (mq *LpacaMQ) deadLetterUndecodable(msg *Message, err error) {
+	log.Printf("[LpacaMQ] Message %s on %s dead-lettered: %v", msg.ID, msg.Topic, err)
+	topic, terr := mq.GetTopic(msg.Topic)
+	if terr != nil {
+		log.Printf("[LpacaMQ] Failed to dead-letter message %s: %v", msg.ID, terr)
+		return
+	}
+	if err := topic.deadLetter(msg.queued()); err != nil { // as published, not the decoded copy
+		log.Printf("[LpacaMQ] Failed to dead-letter message %s: %v", msg.ID, err)
+	}
+}
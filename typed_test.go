@@ -0,0 +1,95 @@
+package lpacamq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type order struct {
+	ID     string
+	Amount int
+}
+
+func TestTypedPublishSubscribe(t *testing.T) {
+	for _, contentType := range []string{ContentTypeJSON, ContentTypeGob} {
+		t.Run(contentType, func(t *testing.T) {
+			mq := New()
+			defer mq.Close()
+
+			pub, err := NewPublisher[order](mq, "orders", contentType)
+			if err != nil {
+				t.Fatalf("NewPublisher failed: %v", err)
+			}
+
+			got := make(chan order, 1)
+			Subscribe(mq, "orders", func(ctx context.Context, o order, msg *Message) error {
+				if msg.Headers[ContentTypeHeader] != contentType {
+					t.Errorf("Expected content type %s, got %s", contentType, msg.Headers[ContentTypeHeader])
+				}
+				got <- o
+				return nil
+			})
+
+			want := order{ID: "o-1", Amount: 42}
+			if _, err := pub.Publish(context.Background(), want, WithHeaders(map[string]string{"source": "test"})); err != nil {
+				t.Fatalf("Publish failed: %v", err)
+			}
+			select {
+			case o := <-got:
+				if o != want {
+					t.Errorf("Expected %+v, got %+v", want, o)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Typed handler was not called")
+			}
+		})
+	}
+
+	if _, err := NewPublisher[order](New(), "orders", "text/csv"); err == nil {
+		t.Error("Expected an unknown content type to be rejected")
+	}
+}
+
+func TestTypedDecodeFailureDeadLetters(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	called := make(chan struct{}, 1)
+	Subscribe(mq, "orders", func(ctx context.Context, o order, msg *Message) error {
+		called <- struct{}{}
+		return nil
+	})
+
+	mq.Publish("orders", []byte("not json"), WithHeaders(map[string]string{ContentTypeHeader: "application/json; charset=utf-8"}))
+	mq.Publish("orders", []byte("{}"), WithHeaders(map[string]string{ContentTypeHeader: "text/csv"}))
+
+	topic, _ := mq.GetTopic("orders")
+	deadline := time.Now().Add(time.Second)
+	for topic.DeadLetters().Len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := topic.DeadLetters().Len(); n != 2 {
+		t.Errorf("Expected 2 dead letters, got %d", n)
+	}
+	select {
+	case <-called:
+		t.Error("Handler should not see undecodable messages")
+	default:
+	}
+}
+
+func TestTypedPublishContinuesTrace(t *testing.T) {
+	mq := New()
+	defer mq.Close()
+
+	pub, _ := NewPublisher[order](mq, "orders", "")
+	parent := TraceContext{TraceID: GenerateTraceID(), SpanID: GenerateSpanID(), Flags: 1}
+	msg, err := pub.Publish(ContextWithTrace(context.Background(), parent), order{ID: "o-2"})
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if tc, _ := msg.TraceContext(); tc.TraceID != parent.TraceID {
+		t.Errorf("Expected trace %s, got %s", parent.TraceID, tc.TraceID)
+	}
+}
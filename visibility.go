@@ -0,0 +1,122 @@
+package lpacamq
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultVisibilityTimeout is how long a received message stays hidden
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// lease hides a received message until it is deleted or its timer fires
+type lease struct {
+	msg      *Message
+	deadline time.Time
+	timer    *time.Timer
+	trace    *deliveryTrace // nil for untraced receives
+}
+
+// Receive hands out the next message and hides it for the visibility timeout
+// instead of removing it. The message must be deleted with the returned
+// receipt handle before the timeout lapses, otherwise it becomes visible
+// again at the head of the q with its ReceiveCount incremented
+func (q *Queue) Receive(visibility time.Duration) (*Message, string, bool) {
+	if visibility <= 0 {
+		visibility = DefaultVisibilityTimeout
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, "", false
+	}
+	i := q.next()
+	if i < 0 {
+		return nil, "", false
+	}
+
+	msg := q.take(i)
+	msg.ReceiveCount++
+
+	receipt := GenerateID()
+	q.leases[receipt] = &lease{
+		msg:      msg,
+		deadline: time.Now().Add(visibility),
+		timer:    time.AfterFunc(visibility, func() { q.expire(receipt) }),
+	}
+	return msg, receipt, true
+}
+
+// DeleteMessage removes a received message for good
+func (q *Queue) DeleteMessage(receipt string) error {
+	q.mu.Lock()
+	l, ok := q.leases[receipt]
+	if !ok {
+		q.mu.Unlock()
+		return fmt.Errorf("receipt handle %s is invalid or expired", receipt)
+	}
+	l.timer.Stop()
+	delete(q.leases, receipt)
+	q.mu.Unlock()
+
+	l.trace.delivered(nil)
+	ack := l.trace.settle("ack")
+	q.Release(l.msg)
+	ack.End(nil)
+	return nil
+}
+
+// traceLease attaches a delivery trace to a lease, finishing it at once if
+// the lease is already gone
+func (q *Queue) traceLease(receipt string, d *deliveryTrace) {
+	q.mu.Lock()
+	l, ok := q.leases[receipt]
+	if ok {
+		l.trace = d
+	}
+	q.mu.Unlock()
+
+	if !ok {
+		d.returned()
+	}
+}
+
+// ChangeVisibility restarts the visibility timeout of a received message,
+// a zero timeout makes it visible again immediately
+func (q *Queue) ChangeVisibility(receipt string, timeout time.Duration) error {
+	q.mu.Lock()
+	l, ok := q.leases[receipt]
+	if !ok {
+		q.mu.Unlock()
+		return fmt.Errorf("receipt handle %s is invalid or expired", receipt)
+	}
+	if timeout > 0 && l.timer.Stop() {
+		l.deadline = time.Now().Add(timeout)
+		l.timer.Reset(timeout)
+		q.mu.Unlock()
+		return nil
+	}
+	q.mu.Unlock()
+
+	q.expire(receipt)
+	return nil
+}
+
+// expire makes a leased message visible again
+func (q *Queue) expire(receipt string) {
+	q.mu.Lock()
+	l, ok := q.leases[receipt]
+	if !ok {
+		q.mu.Unlock()
+		return // deleted in the meantime
+	}
+	l.timer.Stop()
+	delete(q.leases, receipt)
+	if !q.closed {
+		q.requeueFront(l.msg)
+	}
+	q.mu.Unlock()
+
+	l.trace.returned()
+}
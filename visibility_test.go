@@ -0,0 +1,71 @@
+package lpacamq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReceiveDelete(t *testing.T) {
+	q := NewQueue()
+	q.Push(NewMessage("test", []byte("data")))
+
+	msg, receipt, ok := q.Receive(time.Second)
+	if !ok {
+		t.Fatal("Expected a message")
+	}
+	if msg.ReceiveCount != 1 {
+		t.Errorf("Expected receive count 1, got %d", msg.ReceiveCount)
+	}
+	if q.Len() != 0 || q.InFlight() != 1 {
+		t.Errorf("Expected message hidden, len %d in-flight %d", q.Len(), q.InFlight())
+	}
+
+	if err := q.DeleteMessage(receipt); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if err := q.DeleteMessage(receipt); err == nil {
+		t.Error("Deleting twice should fail")
+	}
+	if q.InFlight() != 0 {
+		t.Errorf("Expected nothing in flight, got %d", q.InFlight())
+	}
+}
+
+func TestReceiveVisibilityTimeout(t *testing.T) {
+	q := NewQueue()
+	q.Push(NewMessage("test", []byte("data")))
+
+	_, receipt, _ := q.Receive(20 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	msg, _, ok := q.Receive(time.Second)
+	if !ok {
+		t.Fatal("Message should be visible again after the timeout")
+	}
+	if msg.ReceiveCount != 2 {
+		t.Errorf("Expected receive count 2, got %d", msg.ReceiveCount)
+	}
+	if err := q.DeleteMessage(receipt); err == nil {
+		t.Error("Expired receipt handle should be rejected")
+	}
+}
+
+func TestChangeVisibility(t *testing.T) {
+	q := NewQueue()
+	q.Push(NewMessage("test", []byte("data")))
+
+	_, receipt, _ := q.Receive(20 * time.Millisecond)
+	if err := q.ChangeVisibility(receipt, time.Second); err != nil {
+		t.Fatalf("ChangeVisibility failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, _, ok := q.Receive(time.Second); ok {
+		t.Error("Extended message should still be hidden")
+	}
+
+	// Zero timeout releases it immediately
+	q.ChangeVisibility(receipt, 0)
+	if _, _, ok := q.Receive(time.Second); !ok {
+		t.Error("Message should be visible after zero visibility")
+	}
+}